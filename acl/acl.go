@@ -0,0 +1,120 @@
+package acl
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// ACL IP访问控制列表
+// 支持 IPv4/IPv6 CIDR 规则，拒绝规则优先；允许列表为空时默认允许，否则仅允许命中允许规则的地址
+// 规则可通过 Reload 在运行时原子替换，Allowed 并发安全
+type ACL struct {
+	rules atomic.Pointer[ruleSet]
+}
+
+type ruleSet struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// New 新建访问控制列表，规则可为 CIDR（如 10.0.0.0/8、fd00::/8）或单个IP
+func New(allow, deny []string) (*ACL, error) {
+	a := &ACL{}
+	err := a.Reload(allow, deny)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload 原子替换全部规则，解析失败时保留原规则
+func (this *ACL) Reload(allow, deny []string) error {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	this.rules.Store(&ruleSet{
+		allow: allowPrefixes,
+		deny:  denyPrefixes,
+	})
+	return nil
+}
+
+// Allowed 判断IP是否允许访问
+func (this *ACL) Allowed(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	return this.allowed(addr)
+}
+
+// AllowedAddr 判断网络地址是否允许访问，非IP地址（如 Unix socket）总是允许
+func (this *ACL) AllowedAddr(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return this.Allowed(addr.IP)
+	case *net.UDPAddr:
+		return this.Allowed(addr.IP)
+	case *net.IPAddr:
+		return this.Allowed(addr.IP)
+	}
+	return true
+}
+
+func (this *ACL) allowed(addr netip.Addr) bool {
+	rules := this.rules.Load()
+	if rules == nil {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range rules.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, prefix := range rules.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析规则列表
+func parsePrefixes(rules []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if !strings.Contains(rule, "/") {
+			addr, err := netip.ParseAddr(rule)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(rule)
+		if err != nil {
+			return nil, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,68 @@
+package acl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACL_Allowed(t *testing.T) {
+	a, err := New([]string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.1"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.2.3.4":         true,
+		"10.1.2.3":         false,
+		"192.168.1.1":      true,
+		"192.168.1.2":      false,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"::ffff:10.2.3.4":  true,
+		"::ffff:10.1.2.3":  false,
+		"::ffff:127.0.0.1": false,
+	}
+	for ip, expect := range cases {
+		if a.Allowed(net.ParseIP(ip)) != expect {
+			t.Fatal(ip, "expect", expect)
+		}
+	}
+}
+
+func TestACL_DenyOnly(t *testing.T) {
+	a, err := New(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Allowed(net.ParseIP("127.0.0.1")) {
+		t.Fatal("127.0.0.1 should be denied")
+	}
+	if !a.Allowed(net.ParseIP("8.8.8.8")) {
+		t.Fatal("8.8.8.8 should be allowed")
+	}
+	if !a.AllowedAddr(&net.UnixAddr{Name: "/tmp/libnet.sock", Net: "unix"}) {
+		t.Fatal("unix addr should be allowed")
+	}
+}
+
+func TestACL_Reload(t *testing.T) {
+	a, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("172.16.0.1")
+	if !a.Allowed(ip) {
+		t.Fatal("empty acl should allow all")
+	}
+	if err := a.Reload(nil, []string{"172.16.0.0/12"}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Allowed(ip) {
+		t.Fatal("reloaded rules not applied")
+	}
+	if err := a.Reload([]string{"bad"}, nil); err == nil {
+		t.Fatal("expect parse error")
+	}
+	if a.Allowed(ip) {
+		t.Fatal("rules should be kept after failed reload")
+	}
+}
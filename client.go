@@ -4,8 +4,11 @@ import (
 	"crypto/tls"
 	"fmt"
 	options2 "github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/rudp"
 	"github.com/1uLang/libnet/utils"
 	"net"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -14,6 +17,8 @@ type Client struct {
 	address string
 	handler Handler
 	conn    *Connection
+
+	unixgramDir string // unixgram 本地地址所在的临时目录，Close 时删除
 }
 
 func NewClient(address string, handler Handler, opts ...options2.Option) (*Client, error) {
@@ -32,50 +37,108 @@ func (c *Client) Write(bytes []byte) (int, error) {
 	return c.conn.Write(bytes)
 }
 func (c *Client) DialTCP() error {
-
-	timeout := c.options.Timeout
-	if c.options.Timeout == 0 { // 默认3秒
-		timeout = 3 * time.Second
-	}
-	rawConn, err := net.DialTimeout("tcp", c.address, timeout)
+	rawConn, err := net.DialTimeout("tcp", c.address, c.dialTimeout())
 	if err != nil {
 		return err
 	}
-	//rawConn.(*net.TCPConn).SetLinger(0)
-	c.conn = newConnection(rawConn, c.handler, c.options, false, true)
+	applySocketOptions(rawConn, c.options)
+	c.conn = newConnection(rawConn, nil, c.handler, c.options, false, true)
 	c.conn.setupTCP()
 	return nil
 }
 
 func (c *Client) DialUDP() error {
+	rawConn, err := net.DialTimeout("udp", c.address, c.dialTimeout())
+	if err != nil {
+		return err
+	}
+	applySocketOptions(rawConn, c.options)
+	c.conn = newConnection(rawConn, nil, c.handler, c.options, true, true)
+	c.conn.setupUDP()
+	return nil
+}
 
-	timeout := c.options.Timeout
-	if c.options.Timeout == 0 { // 默认3秒
-		timeout = 3 * time.Second
+// DialRUDP 连接可靠UDP服务，连接按流式连接处理
+func (c *Client) DialRUDP() error {
+	raddr, err := net.ResolveUDPAddr("udp", c.address)
+	if err != nil {
+		return err
 	}
-	rawConn, err := net.DialTimeout("udp", c.address, timeout)
+	pc, err := net.ListenUDP("udp", nil)
 	if err != nil {
 		return err
 	}
-	//err = rawConn.(*net.UDPConn).SetWriteBuffer(4 * 1024 * 1024)
-	c.conn = newConnection(rawConn, c.handler, c.options, true, true)
+	rawConn, err := rudp.Client(newRUDPPacketConn(pc, c.options), raddr, c.dialTimeout(), nil)
+	if err != nil {
+		return err
+	}
+	c.conn = newConnection(rawConn, nil, c.handler, c.options, false, true)
+	// 不支持 netpoll，使用阻塞读取
+	go c.conn.setupTCP()
 	return nil
 }
 func (c *Client) DialTLS(cfg *tls.Config) error {
 	var rawConn net.Conn
 	var err error
-	timeout := c.options.Timeout
-	if c.options.Timeout == 0 { // 默认3秒
-		timeout = 3 * time.Second
-	}
-	rawConn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", c.address, cfg)
+	rawConn, err = tls.DialWithDialer(&net.Dialer{Timeout: c.dialTimeout()}, "tcp", c.address, cfg)
 	if err != nil {
 		return err
 	}
-	c.conn = newConnection(rawConn, c.handler, c.options, false, true)
+	applySocketOptions(rawConn, c.options)
+	c.conn = newConnection(rawConn, nil, c.handler, c.options, false, true)
 	c.conn.setupTLS()
 	return nil
 }
+
+// DialUnix 以 address 为路径连接 Unix 流式服务
+func (c *Client) DialUnix() error {
+	rawConn, err := net.DialTimeout("unix", c.address, c.dialTimeout())
+	if err != nil {
+		return err
+	}
+	applySocketOptions(rawConn, c.options)
+	c.conn = newConnection(rawConn, nil, c.handler, c.options, false, true)
+	c.conn.setupTCP()
+	return nil
+}
+
+// DialUnixgram 以 address 为路径连接 Unix 数据报服务
+// 本地绑定临时目录中的路径，服务端据此区分会话并回复，Close 时删除
+func (c *Client) DialUnixgram() error {
+	raddr, err := net.ResolveUnixAddr("unixgram", c.address)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "libnet")
+	if err != nil {
+		return err
+	}
+	laddr := &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: "unixgram"}
+	rawConn, err := net.DialUnix("unixgram", laddr, raddr)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	c.unixgramDir = dir
+	applySocketOptions(rawConn, c.options)
+	c.conn = newConnection(rawConn, nil, c.handler, c.options, true, true)
+	c.conn.setupUDP()
+	return nil
+}
+
 func (c *Client) Close() error {
-	return c.conn.Close("")
+	err := c.conn.Close("")
+	if c.unixgramDir != "" {
+		_ = os.RemoveAll(c.unixgramDir)
+		c.unixgramDir = ""
+	}
+	return err
+}
+
+// 连接超时时间，默认3秒
+func (c *Client) dialTimeout() time.Duration {
+	if c.options.Timeout == 0 {
+		return 3 * time.Second
+	}
+	return c.options.Timeout
 }
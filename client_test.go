@@ -0,0 +1,145 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/encrypt"
+	"github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/rudp"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+)
+
+// 启动加密的 UDP 回显服务
+func serveUDP(t *testing.T, method string) (*Serve, string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := encrypt.NewMethodInstance(method, "key", "iv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{}, options.WithEncryptMethod(m))
+	go func() {
+		_ = svr.ServePacketConn(pc)
+	}()
+	return svr, pc.LocalAddr().String()
+}
+
+func TestClient_DialUDP(t *testing.T) {
+	svr, addr := serveUDP(t, "aes-128-cfb")
+	defer svr.Close()
+
+	m, err := encrypt.NewMethodInstance("aes-128-cfb", "key", "iv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &testHandler{}
+	client, err := NewClient(addr, handler, options.WithEncryptMethod(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DialUDP(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// 服务端回显，客户端收到后再次回显，直到关闭
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return handler.connects == 1 && len(handler.messages) >= 2 && string(handler.messages[1]) == "hello"
+	})
+}
+
+func TestClient_DialUDPBuffer(t *testing.T) {
+	svr, addr := serveUDP(t, "raw")
+	defer svr.Close()
+
+	handler := &bufferHandler{}
+	client, err := NewClient(addr, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DialUDP(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, typ := range []byte{typeData, typePong} {
+		if _, err := client.Write((&typedMessage{typ: typ}).Marshal()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return string(handler.received) == string([]byte{typeData, typePong})
+	})
+}
+
+// 按比例丢弃发送的数据报
+type lossyPacketConn struct {
+	net.PacketConn
+	locker sync.Mutex
+	rand   *rand.Rand
+	rate   float64
+}
+
+func (c *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.locker.Lock()
+	drop := c.rand.Float64() < c.rate
+	c.locker.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestClient_DialRUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 服务端丢弃两成发送的数据报，客户端的数据仍按序完整到达
+	ln := rudp.NewListener(&lossyPacketConn{PacketConn: pc, rand: rand.New(rand.NewSource(1)), rate: 0.2}, nil)
+	handler := &bufferHandler{}
+	svr := NewServe("", handler)
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	client, err := NewClient(ln.Addr().String(), &testHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DialRUDP(); err != nil {
+		t.Fatal(err)
+	}
+	var expected []byte
+	for i := 0; i < 1000; i++ {
+		typ := byte(typeData)
+		if i%3 == 0 {
+			typ = typePong
+		}
+		expected = append(expected, typ)
+		if _, err := client.Write((&typedMessage{typ: typ}).Marshal()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return string(handler.received) == string(expected)
+	})
+
+	// 客户端关闭后服务端读取到 EOF 断开
+	_ = client.Close()
+	waitFor(t, func() bool {
+		reasons := handler.closeReasons()
+		return len(reasons) == 1 && reasons[0] == "client close"
+	})
+}
@@ -5,15 +5,14 @@
 package libnet
 
 import (
+	"context"
 	"errors"
 	"github.com/1uLang/libnet/message"
 	"github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/proxyproto"
 	"github.com/1uLang/libnet/utils"
 	"github.com/1uLang/libnet/utils/maps"
-	log "github.com/sirupsen/logrus"
-	"io"
 	"net"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,8 +22,6 @@ var (
 	bytePool         = utils.NewBytePool(10_000, 65536)
 	connId           = int64(0)
 	countConnections = int64(0)
-	connectionMaps   = map[int64]*Connection{}
-	sharedLocker     = sync.Mutex{}
 )
 
 type Connection struct {
@@ -40,194 +37,69 @@ type Connection struct {
 	locker   sync.RWMutex
 	context  maps.Map
 	handler  Handler
+	serve    *Serve
+
+	contextLocker sync.RWMutex // 上下文数据锁，与 locker 分开，避免在 OnClose 中访问上下文时死锁
+
+	proxyHeader *proxyproto.Header // PROXY protocol 头部
+	limitAddr   net.Addr           // 占用服务连接数限制的地址
+
+	queue *writeQueue // 异步发送队列
+
+	stats connStats // 流量统计
+
+	// 超时检查
+	timerLocker   sync.Mutex
+	timers        map[string]*utils.Timer
+	tasks         map[*utils.Timer]struct{} // AfterFunc 添加的任务
+	timersStopped bool
 
 	onClose func()
 }
 
-func newConnection(rawConn net.Conn, handler Handler, opts *options.Options, isUdp, isClient bool) *Connection {
+// 构建连接对象，尚未登记及执行启动回调
+func initConnection(rawConn net.Conn, serve *Serve, handler Handler, opts *options.Options, isUdp, isClient bool) *Connection {
 	conn := &Connection{
 		isUdp:    isUdp,
 		isClient: isClient,
 		conn:     rawConn,
 		options:  opts,
 		handler:  handler,
+		serve:    serve,
 		context:  maps.Map{},
 		locker:   sync.RWMutex{},
 	}
-
-	atomic.AddInt64(&countConnections, 1)
-	sharedLocker.Lock()
-	connId++
-	conn.connId = connId
-	connectionMaps[connId] = conn
-	sharedLocker.Unlock()
-	// 执行启动回调函数
-	if !isUdp && conn.handler != nil {
-		conn.handler.OnConnect(conn)
-	}
 	return conn
 }
 
-// UDP 建立
+// UDP 客户端建立，在新的协程中读取服务端发来的数据报
 func (this *Connection) setupUDP() {
-	// 读取数据
-	buf := bytePool.Get()
-	defer bytePool.Put(buf)
-
 	if this.IsClose() {
 		return
 	}
-	for {
-		n, addr, err := this.conn.(*net.UDPConn).ReadFromUDP(buf)
-		if err != nil {
-			log.Error("[CONNECTION] read from error ", err)
-			continue
-		} else {
-			this.remoteAddr = addr.String()
-			if this.handler != nil {
-				this.handler.OnConnect(this)
-			}
-		}
-		if n > 0 {
-			// udp client 不存在接受消息
-			if !this.isClient && this.handler != nil {
-				if this.options != nil && this.options.EncryptMethod != nil {
-					decode, err := this.options.EncryptMethod.Decrypt(buf[:n])
-					if err != nil {
-						this.fail(errors.New("encryptMethod decrypt bytes fail:" + err.Error()))
-					} else {
-						this.handler.OnMessage(this, decode)
-					}
-				} else {
-					this.handler.OnMessage(this, buf[:n])
-				}
-			}
-		}
-		// Close connection
-		if this.handler != nil {
-			this.handler.OnClose(this, "")
-		}
-	}
+	go this.readLoop()
 }
 
 // TCP 建立
 func (this *Connection) setupTCP() {
-
 	if this.IsClose() {
 		return
 	}
-	// 设置超时
-	if this.options != nil && this.options.Timeout != 0 {
-		this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-	}
-	this.remoteAddr = this.conn.RemoteAddr().String()
-	// 读取数据
-	buf := bytePool.Get()
-	for {
-		n, err := this.conn.Read(buf)
-		if n > 0 {
-			// 设置超时
-			if this.options != nil && this.options.Timeout != 0 {
-				this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-			}
-			if this.buffer != nil || this.handler != nil {
-				if this.options != nil && this.options.EncryptMethod != nil {
-					decode, err := this.options.EncryptMethod.Decrypt(buf[:n])
-					if err != nil {
-						this.fail(err)
-					} else {
-						if this.buffer != nil {
-							this.buffer.Write(decode)
-						} else {
-							this.handler.OnMessage(this, decode)
-						}
-					}
-				} else {
-					if this.buffer != nil {
-						this.buffer.Write(buf[:n])
-					} else {
-						this.handler.OnMessage(this, buf[:n])
-					}
-				}
-			}
-		}
-		if err != nil {
-			bytePool.Put(buf)
-			if io.EOF == err {
-				// 连接断开
-				_ = this.Close("client close")
-				return
-			}
-			if strings.Contains(err.Error(), "timeout") {
-				// 读取超时
-				_ = this.Close("timeout")
-				return
-			}
-		}
+	if this.remoteAddr == "" {
+		this.remoteAddr = this.conn.RemoteAddr().String()
 	}
+	this.readLoop()
 }
 
 // TLS 建立
 func (this *Connection) setupTLS() {
-
 	if this.IsClose() {
 		return
 	}
-	// 设置超时
-	if this.options != nil && this.options.Timeout != 0 {
-		this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-	}
-
-	this.remoteAddr = this.conn.RemoteAddr().String()
-	// 读取数据
-	buf := bytePool.Get()
-	for {
-		n, err := this.conn.Read(buf)
-		if n > 0 {
-			// 设置超时
-			if this.options != nil && this.options.Timeout != 0 {
-				this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-			}
-			if this.buffer != nil || this.handler != nil {
-				if this.options != nil && this.options.EncryptMethod != nil {
-					decode, err := this.options.EncryptMethod.Decrypt(buf[:n])
-					if err != nil {
-						this.fail(err)
-					} else {
-						if this.buffer != nil {
-							this.buffer.Write(decode)
-						} else {
-							this.handler.OnMessage(this, decode)
-						}
-					}
-				} else {
-					if this.buffer != nil {
-						this.buffer.Write(buf[:n])
-					} else {
-						this.handler.OnMessage(this, buf[:n])
-					}
-				}
-			}
-		}
-		if err != nil {
-			bytePool.Put(buf)
-			if io.EOF == err {
-				// 连接断开
-				_ = this.Close("client close")
-				return
-			}
-			if strings.Contains(err.Error(), "timeout") {
-				// 读取超时
-				_ = this.Close("timeout")
-				return
-			}
-		}
+	if this.remoteAddr == "" {
+		this.remoteAddr = this.conn.RemoteAddr().String()
 	}
-}
-
-// 异常
-func (this *Connection) fail(err error) {
-	log.Fatal(err)
+	this.readLoop()
 }
 
 // Close 主动断开连接
@@ -240,16 +112,27 @@ func (this *Connection) Close(reason string) error {
 	this.locker.Lock()
 	if !this.isClosed {
 		if this.handler != nil {
+			start := time.Now()
 			this.handler.OnClose(this, reason)
+			observe(metricOnClose, start)
 		}
 		this.isClosed = true
 		atomic.AddInt64(&countConnections, -1)
 	}
 	this.locker.Unlock()
+	if this.queue != nil {
+		this.queue.close()
+	}
+	this.stopTimers()
+	// 在 locker 之外移出服务登记，避免与分组等操作的锁顺序冲突
+	if this.serve != nil {
+		this.serve.removeConnection(this)
+	}
 	// 执行断开链接回调
 	if this.onClose != nil {
 		this.onClose()
 	}
+	this.clearContext()
 	err := this.conn.Close()
 	if err != nil {
 		return err
@@ -257,6 +140,20 @@ func (this *Connection) Close(reason string) error {
 	return nil
 }
 
+// 发送队列中的消息
+func (this *Connection) flush(bufs net.Buffers) error {
+	_, err := this.send(bufs)
+	return err
+}
+
+// 服务关闭时调用，断开前等待发送队列中的数据发送完毕
+func (this *Connection) shutdown(ctx context.Context, reason string) {
+	go func() {
+		this.drainQueue(ctx)
+		_ = this.Close(reason)
+	}()
+}
+
 // IsClose 是否已断开
 func (this *Connection) IsClose() bool {
 
@@ -265,31 +162,12 @@ func (this *Connection) IsClose() bool {
 	return this.isClosed
 }
 
-// Write 下发消息
-func (this *Connection) Write(bytes []byte) (n int, err error) {
-	if this.IsClose() {
-		return 0, nil
-	}
-	if this.options != nil && this.options.EncryptMethod != nil {
-		bytes, err := this.options.EncryptMethod.Encrypt(bytes)
-		if err != nil {
-			this.fail(err)
-		}
-		return this.conn.Write(bytes)
-	} else {
-		return this.conn.Write(bytes)
-	}
-}
-
 // SetBuffer 设置接受消息监听器[注意当设置监听器之后 handler OnMessage将失效]
 func (this *Connection) SetBuffer(buffer *message.Buffer) error {
 	if this.IsClose() {
 		return nil
 	}
-	// udp client 不存在接受消息 股不存在设置接受消息监听器
-	if this.isUdp && this.isClient {
-		return errors.New("udp client is not to be set ")
-	}
+	this.intercept(buffer)
 	this.buffer = buffer
 	return nil
 }
@@ -303,11 +181,24 @@ func (this *Connection) SetOnClose(onClose func()) error {
 	return nil
 }
 
-// RemoteAddr 远端地址
+// ID 连接ID，进程内唯一
+func (this *Connection) ID() int64 {
+	return this.connId
+}
+
+// ProxyHeader 开启 PROXY protocol 时取得连接的 PROXY 头部，包含真实的来源、目标地址及扩展字段
+func (this *Connection) ProxyHeader() *proxyproto.Header {
+	return this.proxyHeader
+}
+
+// RemoteAddr 远端地址，开启 PROXY protocol 时为真实的来源地址
 func (this *Connection) RemoteAddr() string {
 
 	if this.remoteAddr == "" && !this.IsClose() {
-		return this.conn.RemoteAddr().String()
+		// 未连接的数据报 socket 没有远端地址
+		if addr := this.conn.RemoteAddr(); addr != nil {
+			return addr.String()
+		}
 	}
 	return this.remoteAddr
 }
@@ -0,0 +1,191 @@
+package libnet
+
+import (
+	"bytes"
+	"github.com/1uLang/libnet/message"
+	"github.com/1uLang/libnet/options"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+func newConnection(rawConn net.Conn, serve *Serve, handler Handler, opts *options.Options, isUdp, isClient bool) *Connection {
+	return initConnection(rawConn, serve, handler, opts, isUdp, isClient).open()
+}
+
+// 登记连接并执行启动回调函数
+func (this *Connection) open() *Connection {
+	atomic.AddInt64(&countConnections, 1)
+	this.connId = atomic.AddInt64(&connId, 1)
+	this.stats.open()
+	if this.serve != nil {
+		this.serve.addConnection(this)
+	}
+	// 异步发送队列
+	if !this.isUdp {
+		this.queue = newWriteQueue(this.options)
+		if this.queue != nil {
+			go this.flushLoop()
+		}
+	}
+	// 超时检查，UDP 服务端的会话以空闲超时断开
+	this.startTimeouts()
+	// 执行启动回调函数
+	if this.handler != nil {
+		start := time.Now()
+		this.handler.OnConnect(this)
+		observe(metricOnConnect, start)
+	}
+	return this
+}
+
+// 处理读取到的数据：解密后交由 buffer 或 handler 处理
+func (this *Connection) receive(data []byte) {
+	this.stats.read(len(data))
+	if this.buffer == nil && this.handler == nil {
+		return
+	}
+	if this.options != nil && this.options.EncryptMethod != nil {
+		decode, err := this.options.EncryptMethod.Decrypt(data)
+		if err != nil {
+			this.fail(&DecryptError{Err: err})
+			return
+		}
+		data = decode
+	}
+	start := time.Now()
+	if this.buffer != nil {
+		this.buffer.Write(data)
+	} else {
+		this.stats.messagesRead.Add(1)
+		this.handler.OnMessage(this, data)
+	}
+	observe(metricOnMessage, start)
+}
+
+// 拦截 Buffer 解析出的消息，统计消息数并自动响应心跳请求
+func (this *Connection) intercept(buffer *message.Buffer) {
+	if buffer == nil {
+		return
+	}
+	var codec message.HeartbeatCodec
+	if this.options != nil {
+		codec = this.options.HeartbeatCodec
+	}
+	buffer.Intercept(func(msg message.MessageI) bool {
+		this.stats.messagesRead.Add(1)
+		if codec == nil {
+			return false
+		}
+		if codec.IsPing(msg) {
+			if pong := codec.Pong(msg); pong != nil {
+				_, _ = this.WriteMessage(pong)
+			}
+			return true
+		}
+		return codec.IsPong(msg)
+	})
+}
+
+// 阻塞读取循环，用于不支持 netpoll 的连接，读超时由时间轮检查
+func (this *Connection) readLoop() {
+	// 读取数据
+	buf := bytePool.Get()
+	defer bytePool.Put(buf)
+	for {
+		n, err := this.conn.Read(buf)
+		if n > 0 {
+			this.receive(buf[:n])
+		}
+		if err != nil {
+			if io.EOF == err {
+				// 连接断开
+				_ = this.Close("client close")
+				return
+			}
+			// 连接已被关闭或出现其他读取错误
+			_ = this.Close(err.Error())
+			return
+		}
+	}
+}
+
+// Write 下发消息
+func (this *Connection) Write(bytes []byte) (n int, err error) {
+	if this.IsClose() || this.conn == nil {
+		return 0, nil
+	}
+	if this.options != nil && this.options.EncryptMethod != nil {
+		bytes, err = this.options.EncryptMethod.Encrypt(bytes)
+		if err != nil {
+			err = &WriteError{Err: err}
+			this.fail(err)
+			return 0, err
+		}
+	}
+	return this.write(bytes)
+}
+
+// Writev 将多个缓冲区作为一条消息下发，无需拼接，由 writev 一次发送
+// 开启加解密时拼接后加密发送
+func (this *Connection) Writev(bufs [][]byte) (n int, err error) {
+	if this.IsClose() || this.conn == nil {
+		return 0, nil
+	}
+	if this.options != nil && this.options.EncryptMethod != nil {
+		return this.Write(bytes.Join(bufs, nil))
+	}
+	// 复制一份，避免发送过程中修改调用方的切片
+	return this.writev(append(net.Buffers(nil), bufs...))
+}
+
+// WriteMessage 下发消息，消息实现 message.BuffersMarshaler 时消息头与数据分开发送
+func (this *Connection) WriteMessage(msg message.MessageI) (n int, err error) {
+	if m, ok := msg.(message.BuffersMarshaler); ok {
+		return this.Writev(m.MarshalBuffers())
+	}
+	return this.Write(msg.Marshal())
+}
+
+// 发送已加密的数据
+func (this *Connection) write(bytes []byte) (n int, err error) {
+	return this.writev(net.Buffers{bytes})
+}
+
+// 发送已加密的多个缓冲区，开启发送队列时放入队列
+func (this *Connection) writev(bufs net.Buffers) (n int, err error) {
+	if this.IsClose() || this.conn == nil {
+		return 0, nil
+	}
+	if this.queue != nil {
+		return this.enqueue(bufs)
+	}
+	n, err = this.send(bufs)
+	if err == nil {
+		this.stats.messagesWritten.Add(1)
+	}
+	return n, err
+}
+
+// 同步发送数据，多个缓冲区时由 net.Buffers 发送，TCP 及 unix 连接上使用 writev
+func (this *Connection) send(bufs net.Buffers) (n int, err error) {
+	if timeout := this.writeTimeout(); timeout > 0 {
+		_ = this.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	if len(bufs) == 1 {
+		n, err = this.conn.Write(bufs[0])
+	} else if this.isUdp {
+		// 数据报连接拼接后发送，一条消息为一个数据报
+		n, err = this.conn.Write(bytes.Join(bufs, nil))
+	} else {
+		var written int64
+		written, err = bufs.WriteTo(this.conn)
+		n = int(written)
+	}
+	if err != nil {
+		return n, this.writeFail(err)
+	}
+	this.stats.write(n)
+	return n, nil
+}
@@ -0,0 +1,177 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/utils/maps"
+	"github.com/1uLang/libnet/utils/types"
+	"strconv"
+)
+
+// Set 设置连接上下文数据，用于保存会话状态，连接断开后自动清空
+func (this *Connection) Set(key string, value interface{}) {
+	this.contextLocker.Lock()
+	this.context.Put(key, value)
+	this.contextLocker.Unlock()
+}
+
+// Get 取得连接上下文数据
+func (this *Connection) Get(key string) interface{} {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.Get(key)
+}
+
+// Lookup 取得连接上下文数据，并返回是否存在
+func (this *Connection) Lookup(key string) (value interface{}, ok bool) {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	value, ok = this.context[key]
+	return
+}
+
+// Delete 删除连接上下文数据
+func (this *Connection) Delete(key ...string) {
+	this.contextLocker.Lock()
+	this.context.Delete(key...)
+	this.contextLocker.Unlock()
+}
+
+// Range 遍历连接上下文数据，f 返回 false 时停止遍历
+// 遍历的是数据快照，f 中可以调用 Set/Delete
+func (this *Connection) Range(f func(key string, value interface{}) bool) {
+	this.contextLocker.RLock()
+	snapshot := make(maps.Map, len(this.context))
+	for k, v := range this.context {
+		snapshot[k] = v
+	}
+	this.contextLocker.RUnlock()
+	for k, v := range snapshot {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// GetString 取得字符串类型的上下文数据
+func (this *Connection) GetString(key string) string {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetString(key)
+}
+
+// GetInt 取得int类型的上下文数据
+func (this *Connection) GetInt(key string) int {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetInt(key)
+}
+
+// GetInt64 取得int64类型的上下文数据
+func (this *Connection) GetInt64(key string) int64 {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetInt64(key)
+}
+
+// GetUint64 取得uint64类型的上下文数据
+func (this *Connection) GetUint64(key string) uint64 {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetUint64(key)
+}
+
+// GetFloat64 取得float64类型的上下文数据
+func (this *Connection) GetFloat64(key string) float64 {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetFloat64(key)
+}
+
+// GetBool 取得bool类型的上下文数据
+func (this *Connection) GetBool(key string) bool {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetBool(key)
+}
+
+// GetBytes 取得Byte Slice类型的上下文数据
+func (this *Connection) GetBytes(key string) []byte {
+	this.contextLocker.RLock()
+	defer this.contextLocker.RUnlock()
+	return this.context.GetBytes(key)
+}
+
+// 清空上下文数据
+func (this *Connection) clearContext() {
+	this.contextLocker.Lock()
+	this.context = maps.Map{}
+	this.contextLocker.Unlock()
+}
+
+// ContextValue 取得指定类型的连接上下文数据
+// 数据类型不一致时，数值、字符串及bool类型按 utils/types 规则转换；
+// 数据无法转换（如非数字的字符串转换为数值）或目标为其他类型时返回 false
+func ContextValue[T any](c *Connection, key string) (value T, ok bool) {
+	v, found := c.Lookup(key)
+	if !found {
+		return value, false
+	}
+	if value, ok = v.(T); ok {
+		return value, true
+	}
+	_, toString := any(value).(string)
+	if !convertible(v, toString) {
+		return value, false
+	}
+	switch p := any(&value).(type) {
+	case *string:
+		*p = types.String(v)
+	case *bool:
+		*p = types.Bool(v)
+	case *int:
+		*p = types.Int(v)
+	case *int8:
+		*p = types.Int8(v)
+	case *int16:
+		*p = types.Int16(v)
+	case *int32:
+		*p = types.Int32(v)
+	case *int64:
+		*p = types.Int64(v)
+	case *uint:
+		*p = types.Uint(v)
+	case *uint8:
+		*p = types.Uint8(v)
+	case *uint16:
+		*p = types.Uint16(v)
+	case *uint32:
+		*p = types.Uint32(v)
+	case *uint64:
+		*p = types.Uint64(v)
+	case *float32:
+		*p = types.Float32(v)
+	case *float64:
+		*p = types.Float64(v)
+	default:
+		return value, false
+	}
+	return value, true
+}
+
+// 判断上下文数据能否按 utils/types 规则转换为字符串，或数值及bool类型
+// 数值及bool类型可相互转换，字符串需为数字才能转换为数值及bool类型
+func convertible(v interface{}, toString bool) bool {
+	switch x := v.(type) {
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	case string:
+		return toString || isNumeric(x)
+	case []byte:
+		return toString || isNumeric(string(x))
+	}
+	return false
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,72 @@
+package libnet
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestConnection_Context(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newConnection(server, nil, nil, nil, false, false)
+
+	c.Set("user", "alice")
+	c.Set("level", "3")
+	c.Set("session", &struct{ Id int }{Id: 7})
+	if c.GetString("user") != "alice" || c.GetInt("level") != 3 {
+		t.Fatal("unexpected context values")
+	}
+	if level, ok := ContextValue[int](c, "level"); !ok || level != 3 {
+		t.Fatal("unexpected typed value:", level, ok)
+	}
+	if session, ok := ContextValue[*struct{ Id int }](c, "session"); !ok || session.Id != 7 {
+		t.Fatal("unexpected typed value:", session, ok)
+	}
+	if _, ok := ContextValue[*struct{ Id int }](c, "user"); ok {
+		t.Fatal("unexpected type conversion")
+	}
+	if _, ok := ContextValue[string](c, "missing"); ok {
+		t.Fatal("missing key found")
+	}
+	// 非数字的字符串无法转换为数值及bool类型
+	if level, ok := ContextValue[int](c, "user"); ok {
+		t.Fatal("unexpected numeric conversion:", level)
+	}
+	if _, ok := ContextValue[bool](c, "user"); ok {
+		t.Fatal("unexpected bool conversion")
+	}
+	if level, ok := ContextValue[float64](c, "level"); !ok || level != 3 {
+		t.Fatal("unexpected typed value:", level, ok)
+	}
+
+	count := 0
+	c.Range(func(key string, value interface{}) bool {
+		count++
+		return true
+	})
+	if count != 3 {
+		t.Fatal("unexpected range count:", count)
+	}
+	c.Delete("level")
+	if _, ok := c.Lookup("level"); ok {
+		t.Fatal("key not deleted")
+	}
+
+	// 并发访问
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Set("k", i)
+			_ = c.GetInt("k")
+		}(i)
+	}
+	wg.Wait()
+
+	_ = c.Close("")
+	if c.Get("user") != nil {
+		t.Fatal("context not cleared on close")
+	}
+}
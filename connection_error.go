@@ -0,0 +1,119 @@
+package libnet
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ErrorHandler 连接异常回调接口，Handler 可选实现
+// 回调之后出错的连接将被断开，断开原因为错误描述；UDP 服务端出错的会话同样被断开，对端的下一个数据报将建立新会话
+type ErrorHandler interface {
+	OnError(c *Connection, err error) // 连接异常回调
+}
+
+// DecryptError 接收数据解密失败
+type DecryptError struct {
+	Err error
+}
+
+func (e *DecryptError) Error() string {
+	return "decrypt fail: " + e.Err.Error()
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// PollerError netpoll 注册失败
+type PollerError struct {
+	Op  string // 出错的操作
+	Err error
+}
+
+func (e *PollerError) Error() string {
+	return "poller " + e.Op + " fail: " + e.Err.Error()
+}
+
+func (e *PollerError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError 发送数据失败，包括加密失败
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return "write fail: " + e.Err.Error()
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// 异常 记录并回调后断开连接
+func (this *Connection) fail(err error) {
+	this.report(err)
+	_ = this.Close(err.Error())
+}
+
+// 发送失败，写超时以 "write timeout" 断开连接
+func (this *Connection) writeFail(err error) error {
+	err = &WriteError{Err: err}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		this.fail(err)
+		return err
+	}
+	this.report(err)
+	_ = this.Close(CloseWriteTimeout)
+	return err
+}
+
+// 记录并回调异常
+func (this *Connection) report(err error) {
+	var decryptErr *DecryptError
+	if errors.As(err, &decryptErr) {
+		this.stats.decryptErrors.Add(1)
+		metricDecryptErrors.Inc()
+	}
+	log.Error("[Connection] ", this.RemoteAddr(), " ", err.Error(), callerLocation())
+	if h, ok := this.handler.(ErrorHandler); ok {
+		h.OnError(this, err)
+	}
+}
+
+// 调用stack
+func callerLocation() string {
+	_, currentFilename, _, currentOk := runtime.Caller(0)
+	if !currentOk {
+		return ""
+	}
+	for i := 1; i < 32; i++ {
+		_, filename, lineNo, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+
+		if filename == currentFilename {
+			continue
+		}
+
+		goPath := os.Getenv("GOPATH")
+		if len(goPath) > 0 {
+			absGoPath, err := filepath.Abs(goPath)
+			if err == nil {
+				filename = strings.TrimPrefix(filename, absGoPath)[1:]
+			}
+		} else if strings.Contains(filename, "src") {
+			filename = filename[strings.Index(filename, "src"):]
+		}
+
+		return "\n\t\t" + filename + ":" + fmt.Sprintf("%d", lineNo)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package libnet
+
+import (
+	"errors"
+	"github.com/1uLang/libnet/options"
+	"net"
+	"testing"
+	"time"
+)
+
+// 遇到 "bad" 时解密失败的加解密方法
+type badMethod struct{}
+
+func (badMethod) Init(key []byte, iv []byte) error { return nil }
+
+func (badMethod) Encrypt(src []byte) ([]byte, error) { return src, nil }
+
+func (badMethod) Decrypt(dst []byte) ([]byte, error) {
+	if string(dst) == "bad" {
+		return nil, errors.New("bad data")
+	}
+	return dst, nil
+}
+
+func (badMethod) Method() uint8 { return 0xff }
+
+type errorHandler struct {
+	testHandler
+	errs chan error
+}
+
+func (h *errorHandler) OnError(c *Connection, err error) {
+	h.errs <- err
+}
+
+func TestConnection_OnError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &errorHandler{errs: make(chan error, 1)}
+	svr := NewServe("", handler, options.WithEncryptMethod(badMethod{}))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("bad"))
+
+	select {
+	case err := <-handler.errs:
+		var decryptErr *DecryptError
+		if !errors.As(err, &decryptErr) {
+			t.Fatal("unexpected error:", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnError not called")
+	}
+	// 只断开出错的连接
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection not closed")
+	}
+	waitFor(t, func() bool {
+		reasons := handler.closeReasons()
+		return len(reasons) == 1 && reasons[0] == "decrypt fail: bad data"
+	})
+
+	other, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_, _ = other.Write([]byte("good"))
+	buf := make([]byte, 8)
+	_ = other.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := other.Read(buf)
+	if err != nil || string(buf[:n]) != "good" {
+		t.Fatal("unexpected echo:", string(buf[:n]), err)
+	}
+}
@@ -0,0 +1,30 @@
+package libnet
+
+import "time"
+
+// 心跳请求定时任务
+const heartbeatPing = "heartbeat ping"
+
+// 开启心跳：超过间隔未发送数据时发送心跳请求，超过超时时间未收到任何数据时断开
+func (this *Connection) startHeartbeat() {
+	if this.options.HeartbeatInterval <= 0 || this.options.HeartbeatCodec == nil {
+		return
+	}
+	this.watch(CloseHeartbeatTimeout, this.options.HeartbeatTimeout, &this.stats.lastRead)
+
+	interval := this.options.HeartbeatInterval
+	var ping func()
+	ping = func() {
+		if this.IsClose() {
+			return
+		}
+		elapsed := time.Duration(time.Now().UnixNano() - this.stats.lastWrite.Load())
+		if elapsed < interval {
+			this.schedule(heartbeatPing, interval-elapsed, ping)
+			return
+		}
+		_, _ = this.WriteMessage(this.options.HeartbeatCodec.Ping())
+		this.schedule(heartbeatPing, interval, ping)
+	}
+	this.schedule(heartbeatPing, interval, ping)
+}
@@ -0,0 +1,162 @@
+package libnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/1uLang/libnet/message"
+	"github.com/1uLang/libnet/options"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	typePing = 1
+	typePong = 2
+	typeData = 3
+)
+
+// [1字节类型][4字节数据长度][数据]
+type typedMessage struct {
+	typ  byte
+	data []byte
+}
+
+func (m *typedMessage) Marshal() []byte {
+	buf := make([]byte, 5, 5+len(m.data))
+	buf[0] = m.typ
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(m.data)))
+	return append(buf, m.data...)
+}
+func (m *typedMessage) MsgId() uint64        { return 0 }
+func (m *typedMessage) HeaderLength() uint32 { return 5 }
+func (m *typedMessage) GetLength() uint32    { return uint32(len(m.data)) }
+func (m *typedMessage) SetData(buf []byte)   { m.data = append(m.data, buf...) }
+
+func parseTyped(buf []byte) (message.MessageI, error) {
+	if len(buf) < 5 {
+		return nil, errors.New("short header")
+	}
+	return &typedMessage{typ: buf[0], data: make([]byte, 0, binary.BigEndian.Uint32(buf[1:]))}, nil
+}
+
+// 读取一条消息
+func readTyped(r io.Reader) (*typedMessage, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return &typedMessage{typ: header[0], data: data}, nil
+}
+
+type pingPongCodec struct{}
+
+func (pingPongCodec) Ping() message.MessageI { return &typedMessage{typ: typePing} }
+func (pingPongCodec) IsPing(msg message.MessageI) bool {
+	return msg.(*typedMessage).typ == typePing
+}
+func (pingPongCodec) Pong(ping message.MessageI) message.MessageI {
+	return &typedMessage{typ: typePong}
+}
+func (pingPongCodec) IsPong(msg message.MessageI) bool {
+	return msg.(*typedMessage).typ == typePong
+}
+
+// 使用 Buffer 解析消息的处理器
+type bufferHandler struct {
+	testHandler
+	locker   sync.Mutex
+	received []byte
+}
+
+func (h *bufferHandler) OnConnect(c *Connection) {
+	h.testHandler.OnConnect(c)
+	buffer := message.NewBuffer(parseTyped)
+	buffer.OnMessage(func(msg message.MessageI) {
+		h.locker.Lock()
+		h.received = append(h.received, msg.(*typedMessage).typ)
+		h.locker.Unlock()
+	})
+	c.SetBuffer(buffer)
+}
+
+func TestConnection_HeartbeatTimeout(t *testing.T) {
+	handler, svr, conn := dialServe(t,
+		options.WithHeartbeat(30*time.Millisecond, 150*time.Millisecond, pingPongCodec{}))
+	defer svr.Close()
+	defer conn.Close()
+
+	// 对端不响应，收到心跳请求后连接因心跳超时断开
+	pings := int32(0)
+	go func() {
+		for {
+			msg, err := readTyped(conn)
+			if err != nil {
+				return
+			}
+			if msg.typ == typePing {
+				atomic.AddInt32(&pings, 1)
+			}
+		}
+	}()
+	waitFor(t, func() bool {
+		return len(handler.closeReasons()) == 1
+	})
+	if reasons := handler.closeReasons(); reasons[0] != CloseHeartbeatTimeout {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+	if atomic.LoadInt32(&pings) < 2 {
+		t.Fatal("unexpected ping count:", atomic.LoadInt32(&pings))
+	}
+}
+
+func TestConnection_HeartbeatPong(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &bufferHandler{}
+	svr := NewServe("", handler, options.WithHeartbeat(time.Second, 150*time.Millisecond, pingPongCodec{}))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 对端定时发送心跳请求，服务端自动响应且不交由 OnMessage 处理
+	for i := 0; i < 6; i++ {
+		if _, err := conn.Write((&typedMessage{typ: typePing}).Marshal()); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := readTyped(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.typ != typePong {
+			t.Fatal("unexpected message type:", msg.typ)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := conn.Write((&typedMessage{typ: typeData, data: []byte("hi")}).Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return len(handler.received) == 1
+	})
+	if handler.received[0] != typeData || len(handler.closeReasons()) != 0 {
+		t.Fatal("unexpected state:", handler.received, handler.closeReasons())
+	}
+}
@@ -3,23 +3,21 @@
 package libnet
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"github.com/1uLang/libnet/message"
 	"github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/proxyproto"
 	"github.com/1uLang/libnet/utils"
 	"github.com/1uLang/libnet/utils/maps"
 	"github.com/1uLang/libnet/workers"
 	"github.com/mailru/easygo/netpoll"
-	log "github.com/sirupsen/logrus"
 	"net"
 	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -28,10 +26,15 @@ var (
 	bytePool         = utils.NewBytePool(10_000, 65536)
 	connId           = int64(0)
 	countConnections = int64(0)
-	connectionMaps   = map[int64]*Connection{}
-	sharedLocker     = sync.Mutex{}
 )
 
+// 支持 netpoll 注册的连接
+type pollConn interface {
+	net.Conn
+	syscall.Conn
+	File() (*os.File, error)
+}
+
 type Connection struct {
 	conn       net.Conn
 	desc       *netpoll.Desc
@@ -47,74 +50,91 @@ type Connection struct {
 	locker   sync.RWMutex
 	context  maps.Map
 	handler  Handler
+	serve    *Serve
+
+	contextLocker sync.RWMutex // 上下文数据锁，与 locker 分开，避免在 OnClose 中访问上下文时死锁
+
+	proxyHeader *proxyproto.Header // PROXY protocol 头部
+	limitAddr   net.Addr           // 占用服务连接数限制的地址
+
+	queue   *writeQueue     // 异步发送队列
+	rawConn syscall.RawConn // 开启发送队列时用于非阻塞写入
+
+	stats connStats // 流量统计
+
+	// 超时检查
+	timerLocker   sync.Mutex
+	timers        map[string]*utils.Timer
+	tasks         map[*utils.Timer]struct{} // AfterFunc 添加的任务
+	timersStopped bool
 
 	onClose func()
 }
 
-func newConnection(rawConn net.Conn, handler Handler, opts *options.Options, isUdp, isClient bool) *Connection {
+// 构建连接对象，尚未登记及执行启动回调
+func initConnection(rawConn net.Conn, serve *Serve, handler Handler, opts *options.Options, isUdp, isClient bool) *Connection {
 	conn := &Connection{
 		isUdp:    isUdp,
 		isClient: isClient,
 		conn:     rawConn,
 		options:  opts,
 		handler:  handler,
+		serve:    serve,
 		worker:   workers.NewWorker(""),
 		context:  maps.Map{},
 		locker:   sync.RWMutex{},
 	}
-
-	atomic.AddInt64(&countConnections, 1)
-	sharedLocker.Lock()
-	connId++
-	conn.connId = connId
-	connectionMaps[connId] = conn
-	sharedLocker.Unlock()
-	// 执行启动回调函数
-	if !isUdp && conn.handler != nil {
-		conn.handler.OnConnect(conn)
-	}
 	return conn
 }
 
-// UDP 建立
+// UDP 客户端建立，由 netpoll 通知读取服务端发来的数据报
 func (this *Connection) setupUDP() {
-	// 读取数据
-	buf := bytePool.Get()
-	defer bytePool.Put(buf)
-
 	if this.IsClose() {
 		return
 	}
-	for {
-		n, addr, err := this.conn.(*net.UDPConn).ReadFromUDP(buf)
-		if err != nil {
-			log.Error("[CONNECTION] read from error ", err)
-			continue
-		} else {
-			this.remoteAddr = addr.String()
-			if this.handler != nil {
-				this.handler.OnConnect(this)
-			}
-		}
-		if n > 0 {
-			// udp client 不存在接受消息
-			if !this.isClient && this.handler != nil {
-				if this.options != nil && this.options.EncryptMethod != nil {
-					decode, err := this.options.EncryptMethod.Decrypt(buf[:n])
-					if err != nil {
-						this.fail(errors.New("encryptMethod decrypt bytes fail:" + err.Error()))
-					} else {
-						this.handler.OnMessage(this, decode)
+	conn, ok := this.conn.(pollConn)
+	if !ok {
+		go this.readLoop()
+		return
+	}
+	syscallConn, err := conn.SyscallConn()
+	if err != nil {
+		this.fail(&PollerError{Op: "syscall conn", Err: err})
+		return
+	}
+
+	err = this.startPoll(this.conn, nil, func(ev netpoll.Event) {
+		this.worker.Run(func() {
+			// 每次读取一个数据报，直到没有数据可读
+			buf := bytePool.Get()
+			defer bytePool.Put(buf)
+			for {
+				n, err := utils.ReadConn(syscallConn, buf)
+				if err == syscall.EAGAIN || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				if err != nil {
+					this.report(err)
+					// 对端端口不可达不影响后续读取
+					if err == syscall.ECONNREFUSED {
+						continue
 					}
+					return
+				}
+				if n > 0 {
+					this.receive(buf[:n])
 				} else {
-					this.handler.OnMessage(this, buf[:n])
+					// 空数据报只刷新活动时间
+					this.stats.read(0)
 				}
 			}
+		})
+	})
+	if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return
 		}
-		// Close connection
-		if this.handler != nil {
-			this.handler.OnClose(this, "")
-		}
+		this.fail(err)
 	}
 }
 
@@ -123,78 +143,49 @@ func (this *Connection) setupTCP() {
 	if this.IsClose() {
 		return
 	}
-	// 设置超时
-	if this.options != nil && this.options.Timeout != 0 {
-		this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
+	if this.remoteAddr == "" {
+		this.remoteAddr = this.conn.RemoteAddr().String()
 	}
-	this.remoteAddr = this.conn.RemoteAddr().String()
-	// conn
-	desc, err := netpoll.Handle(this.conn, netpoll.EventRead|netpoll.EventEdgeTriggered)
-	if err != nil {
-		this.fail(errors.New("tls net poll handle " + err.Error()))
-		_ = this.Close(err.Error())
+	conn, ok := this.conn.(pollConn)
+	if !ok {
+		// 不支持 netpoll 的连接（如包装过的监听器），使用阻塞读取
+		this.readLoop()
 		return
 	}
-	this.desc = desc
-
-	syscallConn, err := this.conn.(*net.TCPConn).SyscallConn()
+	syscallConn, err := conn.SyscallConn()
 	if err != nil {
-		this.fail(errors.New("tcp SyscallConn " + err.Error()))
-		_ = this.Close(err.Error())
+		this.fail(&PollerError{Op: "syscall conn", Err: err})
 		return
 	}
 
-	err = poller.Start(desc, func(ev netpoll.Event) {
+	err = this.startPoll(this.conn, syscallConn, func(ev netpoll.Event) {
 		this.worker.Run(func() {
 			// 读取数据
 			buf := bytePool.Get()
+			hup := false
 			for {
 				n, err := utils.ReadConn(syscallConn, buf)
-				if err != nil && strings.Contains(err.Error(), "timeout") {
-					bytePool.Put(buf)
-					// 处理读取超时
-					_ = this.Close("timeout")
-					return
-				}
 				if n > 0 {
-					// 设置超时
-					if this.options != nil && this.options.Timeout != 0 {
-						this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-					}
-					if this.buffer != nil || this.handler != nil {
-						if this.options != nil && this.options.EncryptMethod != nil {
-							decode, err := this.options.EncryptMethod.Decrypt(buf[:n])
-							if err != nil {
-								this.fail(err)
-							} else {
-								if this.buffer != nil {
-									this.buffer.Write(decode)
-								} else {
-									this.handler.OnMessage(this, decode)
-								}
-							}
-						} else {
-							if this.buffer != nil {
-								this.buffer.Write(buf[:n])
-							} else {
-								this.handler.OnMessage(this, buf[:n])
-							}
-						}
-					}
+					this.receive(buf[:n])
 				} else {
+					// 读取到 EOF 或出现 EAGAIN 以外的错误
+					hup = n == 0 && err == nil || err != nil && err != syscall.EAGAIN
 					break
 				}
 			}
 			bytePool.Put(buf)
-			// 处理连接断开事件
-			if ev&netpoll.EventReadHup != 0 {
+			// 处理连接断开事件，以实际读取结果为准，fd 被复用时 netpoll 可能送达已关闭连接的事件
+			if hup {
 				_ = this.Close("client hup")
 				return
 			}
 		})
 	})
 	if err != nil {
-		this.fail(errors.New("tcp poller " + err.Error()))
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		this.fail(err)
 		return
 	}
 }
@@ -205,60 +196,25 @@ func (this *Connection) setupTLS() {
 	if this.IsClose() {
 		return
 	}
-	// 设置超时
-	if this.options != nil && this.options.Timeout != 0 {
-		this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-	}
 
-	this.remoteAddr = this.conn.RemoteAddr().String()
+	if this.remoteAddr == "" {
+		this.remoteAddr = this.conn.RemoteAddr().String()
+	}
 
 	tlsConn, _ := this.conn.(*tls.Conn)
-	conn, _ := tlsConn.NetConn().(*net.TCPConn)
-	// conn
-	desc, err := netpoll.Handle(conn, netpoll.EventRead|netpoll.EventEdgeTriggered)
-	if err != nil {
-		this.fail(errors.New("tls net poll handle " + err.Error()))
-		_ = this.Close(err.Error())
+	conn, ok := tlsConn.NetConn().(pollConn)
+	if !ok {
+		// 不支持 netpoll 的连接（如包装过的监听器），使用阻塞读取
+		this.readLoop()
 		return
 	}
-	this.desc = desc
-
-	err = poller.Start(desc, func(ev netpoll.Event) {
+	err := this.startPoll(conn, nil, func(ev netpoll.Event) {
 		this.worker.Run(func() {
 			// 读取数据
 			buf := bytePool.Get()
-			n, err := tlsConn.Read(buf)
-			if err != nil && strings.Contains(err.Error(), "timeout") {
-				bytePool.Put(buf)
-				// 处理读取超时
-				_ = this.Close("timeout")
-				return
-			}
+			n, _ := tlsConn.Read(buf)
 			if n > 0 {
-				// 设置超时
-				if this.options != nil && this.options.Timeout != 0 {
-					this.conn.SetReadDeadline(time.Now().Add(this.options.Timeout))
-				}
-				if this.buffer != nil || this.handler != nil {
-					if this.options != nil && this.options.EncryptMethod != nil {
-						decode, err := this.options.EncryptMethod.Decrypt(buf[:n])
-						if err != nil {
-							this.fail(err)
-						} else {
-							if this.buffer != nil {
-								this.buffer.Write(decode)
-							} else {
-								this.handler.OnMessage(this, decode)
-							}
-						}
-					} else {
-						if this.buffer != nil {
-							this.buffer.Write(buf[:n])
-						} else {
-							this.handler.OnMessage(this, buf[:n])
-						}
-					}
-				}
+				this.receive(buf[:n])
 			}
 			bytePool.Put(buf)
 			// 处理连接断开事件
@@ -269,45 +225,93 @@ func (this *Connection) setupTLS() {
 		})
 	})
 	if err != nil {
-		this.fail(errors.New(this.remoteAddr + " tls poller " + err.Error()))
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		this.fail(err)
 		return
 	}
 }
 
-// 异常
-func (this *Connection) fail(err error) {
-	errorString := err.Error()
-
-	// 调用stack
-	_, currentFilename, _, currentOk := runtime.Caller(0)
-	if currentOk {
-		for i := 1; i < 32; i++ {
-			_, filename, lineNo, ok := runtime.Caller(i)
-			if !ok {
-				break
+// 注册 netpoll 读事件，与 Close 互斥，避免注册过程中连接被关闭
+// 开启发送队列且 rawConn 不为空时同时注册写事件，由可写通知驱动发送协程
+func (this *Connection) startPoll(conn net.Conn, rawConn syscall.RawConn, cb func(netpoll.Event)) error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.isClosed {
+		return net.ErrClosed
+	}
+	event := netpoll.EventRead | netpoll.EventEdgeTriggered
+	if this.queue != nil && rawConn != nil {
+		event |= netpoll.EventWrite
+		queue, read := this.queue, cb
+		cb = func(ev netpoll.Event) {
+			if ev&netpoll.EventWrite != 0 {
+				queue.notifyWritable()
 			}
-
-			if filename == currentFilename {
-				continue
+			// 仅为可写通知时无需读取
+			if ev&^netpoll.EventWrite != 0 {
+				read(ev)
 			}
+		}
+	}
+	desc, err := netpoll.Handle(conn, event)
+	if err != nil {
+		return &PollerError{Op: "handle", Err: err}
+	}
+	err = poller.Start(desc, cb)
+	if err != nil {
+		_ = desc.Close()
+		return &PollerError{Op: "start", Err: err}
+	}
+	this.desc = desc
+	if event&netpoll.EventWrite != 0 {
+		this.rawConn = rawConn
+	}
+	return nil
+}
 
-			goPath := os.Getenv("GOPATH")
-			if len(goPath) > 0 {
-				absGoPath, err := filepath.Abs(goPath)
-				if err == nil {
-					filename = strings.TrimPrefix(filename, absGoPath)[1:]
+// 发送队列中的消息，已注册写事件的连接使用非阻塞的 writev 写入，发送缓冲区满时等待可写通知
+func (this *Connection) flush(bufs net.Buffers) error {
+	this.locker.RLock()
+	rawConn := this.rawConn
+	this.locker.RUnlock()
+	if rawConn == nil {
+		_, err := this.send(bufs)
+		return err
+	}
+	var deadline time.Time
+	if timeout := this.writeTimeout(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		_ = this.conn.SetWriteDeadline(deadline)
+	}
+	written := 0
+	for len(bufs) > 0 {
+		n, err := utils.WritevConn(rawConn, bufs)
+		if err == syscall.EAGAIN {
+			var timeout time.Duration
+			if !deadline.IsZero() {
+				timeout = time.Until(deadline)
+				if timeout <= 0 {
+					return this.writeFail(os.ErrDeadlineExceeded)
 				}
-			} else if strings.Contains(filename, "src") {
-				filename = filename[strings.Index(filename, "src"):]
 			}
-
-			errorString += "\n\t\t" + string(filename) + ":" + fmt.Sprintf("%d", lineNo)
-
-			break
+			err = this.queue.waitWritable(timeout)
 		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			return this.writeFail(err)
+		}
+		bufs = utils.ConsumeBuffers(bufs, n)
+		written += n
 	}
-
-	log.Fatal(errorString)
+	this.stats.write(written)
+	return nil
 }
 
 // Close 主动断开连接
@@ -324,24 +328,45 @@ func (this *Connection) Close(reason string) error {
 	this.locker.Lock()
 	if !this.isClosed {
 		if this.handler != nil {
+			start := time.Now()
 			this.handler.OnClose(this, reason)
+			observe(metricOnClose, start)
 		}
 		this.isClosed = true
 		atomic.AddInt64(&countConnections, -1)
 	}
+	desc := this.desc
 	this.locker.Unlock()
+	if this.queue != nil {
+		this.queue.close()
+	}
+	this.stopTimers()
+	// 在 locker 之外移出服务登记，避免与分组等操作的锁顺序冲突
+	if this.serve != nil {
+		this.serve.removeConnection(this)
+	}
 	// 执行断开链接回调
 	if this.onClose != nil {
 		this.onClose()
 	}
+	this.clearContext()
 	// 关闭desc，需要在关闭conn之前
-	if this.desc != nil {
-		_ = poller.Stop(this.desc)
-		_ = this.desc.Close()
+	if desc != nil {
+		_ = poller.Stop(desc)
+		_ = desc.Close()
 	}
 	return this.conn.Close()
 }
 
+// 服务关闭时调用，断开任务排在 worker 已有任务之后，保证在途消息处理完毕，
+// 断开前等待处理消息时写入发送队列的数据发送完毕
+func (this *Connection) shutdown(ctx context.Context, reason string) {
+	go this.worker.Run(func() {
+		this.drainQueue(ctx)
+		_ = this.Close(reason)
+	})
+}
+
 // IsClose 是否已断开
 func (this *Connection) IsClose() bool {
 	this.locker.RLock()
@@ -349,28 +374,12 @@ func (this *Connection) IsClose() bool {
 	return this.isClosed
 }
 
-// Write 下发消息
-func (this *Connection) Write(bytes []byte) (n int, err error) {
-	if this.IsClose() || this.conn == nil {
-		return 0, nil
-	}
-	if this.options != nil && this.options.EncryptMethod != nil {
-		bytes, err := this.options.EncryptMethod.Encrypt(bytes)
-		if err != nil {
-			this.fail(err)
-		}
-		return this.conn.Write(bytes)
-	} else {
-		return this.conn.Write(bytes)
-	}
-}
-
 // SetBuffer 设置接受消息监听器[注意当设置监听器之后 handler OnMessage将失效]
 func (this *Connection) SetBuffer(buffer *message.Buffer) {
-	// udp client 不存在接受消息 股不存在设置接受消息监听器
-	if this.IsClose() || this.isUdp && this.isClient {
+	if this.IsClose() {
 		return
 	}
+	this.intercept(buffer)
 	this.buffer = buffer
 	return
 }
@@ -384,10 +393,23 @@ func (this *Connection) SetOnClose(onClose func()) error {
 	return nil
 }
 
-// RemoteAddr 远端地址
+// ID 连接ID，进程内唯一
+func (this *Connection) ID() int64 {
+	return this.connId
+}
+
+// ProxyHeader 开启 PROXY protocol 时取得连接的 PROXY 头部，包含真实的来源、目标地址及扩展字段
+func (this *Connection) ProxyHeader() *proxyproto.Header {
+	return this.proxyHeader
+}
+
+// RemoteAddr 远端地址，开启 PROXY protocol 时为真实的来源地址
 func (this *Connection) RemoteAddr() string {
 	if this.remoteAddr == "" && this.conn != nil {
-		return this.conn.RemoteAddr().String()
+		// 未连接的数据报 socket 没有远端地址
+		if addr := this.conn.RemoteAddr(); addr != nil {
+			return addr.String()
+		}
 	}
 	return this.remoteAddr
 }
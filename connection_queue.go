@@ -0,0 +1,246 @@
+package libnet
+
+import (
+	"context"
+	"errors"
+	"github.com/1uLang/libnet/options"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrWriteQueueFull = errors.New("the write queue is full")
+
+// 异步发送队列
+type writeQueue struct {
+	locker sync.Mutex
+	space  *sync.Cond // 队列有空闲时通知阻塞的写入方
+
+	items    []net.Buffers
+	inflight int // 已取出、发送中的消息数
+	bytes    int // 队列中及发送中的字节数
+
+	maxMessages int
+	maxBytes    int
+	policy      options.OverflowPolicy
+	batch       int
+
+	closed   bool
+	signal   chan struct{} // 通知发送协程有新消息
+	writable chan struct{} // netpoll 可写通知
+	idle     chan struct{} // 消息全部发送完毕通知
+	done     chan struct{}
+}
+
+func newWriteQueue(opts *options.Options) *writeQueue {
+	if opts == nil || opts.WriteQueueMessages == 0 && opts.WriteQueueBytes == 0 {
+		return nil
+	}
+	q := &writeQueue{
+		maxMessages: opts.WriteQueueMessages,
+		maxBytes:    opts.WriteQueueBytes,
+		policy:      opts.WriteQueuePolicy,
+		batch:       opts.WriteBatch,
+		signal:      make(chan struct{}, 1),
+		writable:    make(chan struct{}, 1),
+		idle:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	q.space = sync.NewCond(&q.locker)
+	return q
+}
+
+// 是否超出上限，队列中及发送中的消息均计入，队列为空时允许单条超过字节上限的消息
+func (q *writeQueue) full(size int) bool {
+	if q.maxMessages > 0 && len(q.items)+q.inflight >= q.maxMessages {
+		return true
+	}
+	return q.maxBytes > 0 && q.bytes > 0 && q.bytes+size > q.maxBytes
+}
+
+// 放入队列，返回 ErrWriteQueueFull 时由调用方按溢出策略处理
+func (q *writeQueue) push(bufs net.Buffers, size int) error {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+	for !q.closed && q.full(size) {
+		if q.policy != options.OverflowBlock {
+			return ErrWriteQueueFull
+		}
+		q.space.Wait()
+	}
+	if q.closed {
+		return ErrConnectionClosed
+	}
+	q.items = append(q.items, bufs)
+	q.bytes += size
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 取出队列中的全部消息，消息发送完毕并 release 前仍占用队列的上限，队列关闭时返回 false
+func (q *writeQueue) pop() ([]net.Buffers, bool) {
+	for {
+		q.locker.Lock()
+		if q.closed {
+			q.locker.Unlock()
+			return nil, false
+		}
+		if len(q.items) > 0 {
+			items := q.items
+			q.items = nil
+			q.inflight += len(items)
+			q.locker.Unlock()
+			return items, true
+		}
+		q.locker.Unlock()
+		select {
+		case <-q.signal:
+		case <-q.done:
+		}
+	}
+}
+
+// 消息发送完毕，释放占用的消息数及字节数
+func (q *writeQueue) release(messages, size int) {
+	q.locker.Lock()
+	q.inflight -= messages
+	q.bytes -= size
+	if q.bytes == 0 {
+		select {
+		case q.idle <- struct{}{}:
+		default:
+		}
+	}
+	q.space.Broadcast()
+	q.locker.Unlock()
+}
+
+// 等待队列中及发送中的消息全部发送完毕，超时或 ctx 取消时返回 false
+func (q *writeQueue) drain(ctx context.Context, timeout time.Duration) bool {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		q.locker.Lock()
+		drained := q.closed || q.bytes == 0
+		q.locker.Unlock()
+		if drained {
+			return true
+		}
+		select {
+		case <-q.idle:
+		case <-q.done:
+		case <-expired:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// 等待 netpoll 可写通知，队列关闭时返回 net.ErrClosed，超时返回 os.ErrDeadlineExceeded
+func (q *writeQueue) waitWritable(timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-q.writable:
+		return nil
+	case <-q.done:
+		return net.ErrClosed
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (q *writeQueue) notifyWritable() {
+	select {
+	case q.writable <- struct{}{}:
+	default:
+	}
+}
+
+// 关闭队列，丢弃未发送的消息并唤醒阻塞的写入方
+func (q *writeQueue) close() {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.items = nil
+	q.space.Broadcast()
+	close(q.done)
+}
+
+// 服务关闭时等待发送队列中的消息发送完毕，以写超时及 ctx 为限
+func (this *Connection) drainQueue(ctx context.Context) {
+	if this.queue == nil {
+		return
+	}
+	if !this.queue.drain(ctx, this.writeTimeout()) {
+		log.Warn("[Connection] ", this.RemoteAddr(), " write queue not drained before shutdown")
+	}
+}
+
+// 放入发送队列，按溢出策略处理队列已满
+func (this *Connection) enqueue(bufs net.Buffers) (int, error) {
+	size := buffersLen(bufs)
+	err := this.queue.push(bufs, size)
+	if err == ErrWriteQueueFull && this.queue.policy == options.OverflowClose {
+		_ = this.Close("write queue overflow")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// 发送协程，按写入顺序发送队列中的消息，开启合并发送时将多条消息合并为一次 writev
+func (this *Connection) flushLoop() {
+	batch := this.queue.batch
+	if batch <= 0 {
+		batch = 1
+	}
+	for {
+		items, ok := this.queue.pop()
+		if !ok {
+			return
+		}
+		for len(items) > 0 {
+			n := batch
+			if n > len(items) {
+				n = len(items)
+			}
+			var bufs net.Buffers
+			for _, item := range items[:n] {
+				bufs = append(bufs, item...)
+			}
+			size := buffersLen(bufs)
+			if err := this.flush(bufs); err != nil {
+				return
+			}
+			this.queue.release(n, size)
+			this.stats.messagesWritten.Add(uint64(n))
+			items = items[n:]
+		}
+	}
+}
+
+func buffersLen(bufs net.Buffers) (n int) {
+	for _, buf := range bufs {
+		n += len(buf)
+	}
+	return n
+}
@@ -0,0 +1,258 @@
+package libnet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/1uLang/libnet/options"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnection_WriteQueueOrder(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	opts := options.GetOptions(options.WithWriteQueue(16, 0, options.OverflowBlock))
+	c := newConnection(server, nil, nil, opts, false, false)
+	defer c.Close("done")
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			_, _ = c.Write([]byte{byte(i)})
+		}
+	}()
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range buf {
+		if int(b) != i {
+			t.Fatal("unexpected order at", i, b)
+		}
+	}
+}
+
+func TestConnection_WriteQueueDrop(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	opts := options.GetOptions(options.WithWriteQueue(2, 0, options.OverflowDrop))
+	c := newConnection(server, nil, nil, opts, false, false)
+	defer c.Close("done")
+
+	// 对端不读取，发送协程阻塞在第一条消息上，发送中的消息计入上限，队列再容纳一条
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		_, err = c.Write([]byte("x"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(err, ErrWriteQueueFull) {
+		t.Fatal("expect queue full, got", err)
+	}
+	if c.IsClose() {
+		t.Fatal("connection closed by drop policy")
+	}
+}
+
+// 对端停止读取时，队列中及发送中的消息总数不超过上限
+func TestConnection_WriteQueueMessagesLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	opts := options.GetOptions(options.WithWriteQueue(4, 0, options.OverflowDrop), options.WithWriteBatch(2))
+	c := newConnection(server, nil, nil, opts, false, false)
+	defer c.Close("done")
+
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if _, err := c.Write([]byte("x")); err == nil {
+			accepted++
+		}
+		// 等待发送协程取出消息
+		time.Sleep(10 * time.Millisecond)
+	}
+	if accepted != 4 {
+		t.Fatal("unexpected buffered messages:", accepted)
+	}
+	c.queue.locker.Lock()
+	buffered := len(c.queue.items) + c.queue.inflight
+	c.queue.locker.Unlock()
+	if buffered != 4 {
+		t.Fatal("unexpected buffered messages:", buffered)
+	}
+
+	// 对端恢复读取后释放占用的上限
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+	waitFor(t, func() bool {
+		_, err := c.Write([]byte("x"))
+		return err == nil
+	})
+}
+
+func TestConnection_WriteQueueClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	handler := &testHandler{}
+	opts := options.GetOptions(options.WithWriteQueue(0, 4, options.OverflowClose))
+	c := newConnection(server, nil, handler, opts, false, false)
+
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		_, err = c.Write([]byte("xxx"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(err, ErrWriteQueueFull) || !c.IsClose() {
+		t.Fatal("expect connection closed by overflow, got", err)
+	}
+	if reasons := handler.closeReasons(); len(reasons) != 1 || reasons[0] != "write queue overflow" {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+}
+
+func TestConnection_WriteQueueBlock(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	opts := options.GetOptions(options.WithWriteQueue(1, 0, options.OverflowBlock))
+	c := newConnection(server, nil, nil, opts, false, false)
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; i < 4 && err == nil; i++ {
+			_, err = c.Write([]byte("x"))
+		}
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("write not blocked by full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 断开连接唤醒阻塞的写入方
+	_ = c.Close("done")
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConnectionClosed) {
+			t.Fatal("unexpected error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked writer not woken by close")
+	}
+}
+
+func TestServe_WriteQueue(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{},
+		options.WithWriteBuffer(4096),
+		options.WithWriteQueue(0, 64*1024, options.OverflowDrop))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool {
+		return svr.Count() == 1
+	})
+	var c *Connection
+	svr.Range(func(item *Connection) bool {
+		c = item
+		return false
+	})
+
+	// 对端不读取，填满 socket 缓冲区及发送队列，写入方不被阻塞
+	chunk := bytes.Repeat([]byte("a"), 8192)
+	sent := 0
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, err := c.Write(chunk)
+		if errors.Is(err, ErrWriteQueueFull) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent += len(chunk)
+		if time.Now().After(deadline) {
+			t.Fatal("write queue never filled")
+		}
+	}
+
+	// 对端开始读取后，由可写通知驱动发送剩余数据
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	received, err := io.CopyN(io.Discard, conn, int64(sent))
+	if err != nil || int(received) != sent {
+		t.Fatal("unexpected received:", received, sent, err)
+	}
+}
+
+// 收到消息后写入大量数据的处理器
+type floodHandler struct {
+	testHandler
+	size    int
+	flooded chan struct{}
+}
+
+func (h *floodHandler) OnMessage(c *Connection, bytes []byte) {
+	chunk := make([]byte, 4096)
+	for sent := 0; sent < h.size; sent += len(chunk) {
+		if _, err := c.Write(chunk); err != nil {
+			return
+		}
+	}
+	close(h.flooded)
+}
+
+func TestServe_ShutdownDrainsWriteQueue(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &floodHandler{size: 4 * 1024 * 1024, flooded: make(chan struct{})}
+	svr := NewServe("", handler,
+		options.WithWriteBuffer(4096),
+		options.WithWriteQueue(0, 0x7fffffff, options.OverflowBlock))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("go"))
+	select {
+	case <-handler.flooded:
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler not flooded")
+	}
+
+	// 对端尚未读取时开始优雅关闭，队列中的数据发送完毕后才断开
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- svr.Shutdown(ctx)
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.Copy(io.Discard, conn)
+	if err != nil || int(received) != handler.size {
+		t.Fatal("unexpected received:", received, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if reasons := handler.closeReasons(); len(reasons) != 1 || reasons[0] != "server shutdown" {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+}
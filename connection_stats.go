@@ -0,0 +1,74 @@
+package libnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 连接流量统计
+type Stats struct {
+	BytesRead       uint64    // 读取的字节数，解密前
+	BytesWritten    uint64    // 发送的字节数，加密后
+	MessagesRead    uint64    // 读取的消息数，设置 Buffer 时为解析出的消息数
+	MessagesWritten uint64    // 发送的消息数
+	DecryptErrors   uint64    // 解密失败次数
+	ConnectedAt     time.Time // 连接建立时间
+	LastRead        time.Time // 最后读取到数据的时间
+	LastWrite       time.Time // 最后发送数据的时间
+	LastActive      time.Time // 最后读取或发送数据的时间
+}
+
+// 连接统计计数，时间为 UnixNano
+type connStats struct {
+	bytesRead       atomic.Uint64
+	bytesWritten    atomic.Uint64
+	messagesRead    atomic.Uint64
+	messagesWritten atomic.Uint64
+	decryptErrors   atomic.Uint64
+	connectedAt     atomic.Int64
+	lastRead        atomic.Int64
+	lastWrite       atomic.Int64
+	lastActive      atomic.Int64
+}
+
+// 连接建立
+func (s *connStats) open() {
+	now := time.Now().UnixNano()
+	s.connectedAt.Store(now)
+	s.lastRead.Store(now)
+	s.lastWrite.Store(now)
+	s.lastActive.Store(now)
+}
+
+// 读取到 n 个字节
+func (s *connStats) read(n int) {
+	now := time.Now().UnixNano()
+	s.bytesRead.Add(uint64(n))
+	metricBytesRead.Add(uint64(n))
+	s.lastRead.Store(now)
+	s.lastActive.Store(now)
+}
+
+// 发送了 n 个字节
+func (s *connStats) write(n int) {
+	now := time.Now().UnixNano()
+	s.bytesWritten.Add(uint64(n))
+	metricBytesWritten.Add(uint64(n))
+	s.lastWrite.Store(now)
+	s.lastActive.Store(now)
+}
+
+// Stats 取得连接流量统计
+func (this *Connection) Stats() Stats {
+	return Stats{
+		BytesRead:       this.stats.bytesRead.Load(),
+		BytesWritten:    this.stats.bytesWritten.Load(),
+		MessagesRead:    this.stats.messagesRead.Load(),
+		MessagesWritten: this.stats.messagesWritten.Load(),
+		DecryptErrors:   this.stats.decryptErrors.Load(),
+		ConnectedAt:     time.Unix(0, this.stats.connectedAt.Load()),
+		LastRead:        time.Unix(0, this.stats.lastRead.Load()),
+		LastWrite:       time.Unix(0, this.stats.lastWrite.Load()),
+		LastActive:      time.Unix(0, this.stats.lastActive.Load()),
+	}
+}
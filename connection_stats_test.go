@@ -0,0 +1,82 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/options"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnection_Stats(t *testing.T) {
+	handler, svr, conn := dialServe(t, options.WithEncryptMethod(badMethod{}))
+	defer svr.Close()
+	defer conn.Close()
+	var c *Connection
+	svr.Range(func(item *Connection) bool {
+		c = item
+		return false
+	})
+
+	buf := make([]byte, 5)
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		return c.Stats().MessagesWritten == 3
+	})
+	stats := c.Stats()
+	if stats.BytesRead != 15 || stats.MessagesRead != 3 || stats.BytesWritten != 15 || stats.DecryptErrors != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.ConnectedAt.IsZero() || stats.LastActive.Before(stats.ConnectedAt) || stats.LastWrite.Before(stats.LastRead) {
+		t.Fatalf("unexpected stats time: %+v", stats)
+	}
+
+	// 解密失败后断开，服务统计保留已断开连接的计数
+	_, _ = conn.Write([]byte("bad"))
+	waitFor(t, func() bool {
+		return len(handler.closeReasons()) == 1
+	})
+	if c.Stats().DecryptErrors != 1 {
+		t.Fatalf("unexpected stats: %+v", c.Stats())
+	}
+	serveStats := svr.Stats()
+	if serveStats.Connections != 0 || serveStats.TotalConnections != 1 || serveStats.BytesRead != 18 ||
+		serveStats.MessagesWritten != 3 || serveStats.DecryptErrors != 1 {
+		t.Fatalf("unexpected serve stats: %+v", serveStats)
+	}
+}
+
+func TestConnection_StatsWriteQueue(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	opts := options.GetOptions(options.WithWriteQueue(16, 0, options.OverflowBlock), options.WithWriteBatch(8))
+	c := newConnection(server, nil, nil, opts, false, false)
+	defer c.Close("done")
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			_, _ = c.Writev([][]byte{[]byte("ab"), []byte("c")})
+		}
+	}()
+	buf := make([]byte, 30)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	for n := 0; n < len(buf); {
+		m, err := client.Read(buf[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	waitFor(t, func() bool {
+		return c.Stats().MessagesWritten == 10
+	})
+	if stats := c.Stats(); stats.BytesWritten != 30 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,109 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/utils"
+	"sync/atomic"
+	"time"
+)
+
+// 所有连接共享的时间轮，用于读超时、空闲超时检查、心跳及 AfterFunc 添加的任务
+var timeWheel = utils.NewTimingWheel(10*time.Millisecond, 1024)
+
+// 开启读超时、空闲超时检查及心跳
+func (this *Connection) startTimeouts() {
+	if this.options == nil {
+		return
+	}
+	if timeout := this.options.GetReadTimeout(); timeout > 0 {
+		this.watch(CloseReadTimeout, timeout, &this.stats.lastRead)
+	}
+	timeout := this.options.IdleTimeout
+	if timeout <= 0 && this.isUdp && !this.isClient {
+		timeout = packetSessionTimeout
+	}
+	if timeout > 0 {
+		this.watch(CloseIdle, timeout, &this.stats.lastActive)
+	}
+	this.startHeartbeat()
+}
+
+// 到期时检查最后活动时间，超时则断开连接，否则按剩余时间重新加入时间轮
+func (this *Connection) watch(reason string, timeout time.Duration, last *atomic.Int64) {
+	var check func()
+	check = func() {
+		if this.IsClose() {
+			return
+		}
+		elapsed := time.Duration(time.Now().UnixNano() - last.Load())
+		if elapsed >= timeout {
+			_ = this.Close(reason)
+			return
+		}
+		this.schedule(reason, timeout-elapsed, check)
+	}
+	this.schedule(reason, timeout, check)
+}
+
+// 加入时间轮，相同 key 的任务只保留最新的一个
+func (this *Connection) schedule(key string, d time.Duration, f func()) {
+	this.timerLocker.Lock()
+	defer this.timerLocker.Unlock()
+	if this.timersStopped {
+		return
+	}
+	if this.timers == nil {
+		this.timers = map[string]*utils.Timer{}
+	}
+	if t, ok := this.timers[key]; ok {
+		t.Stop()
+	}
+	this.timers[key] = timeWheel.AfterFunc(d, f)
+}
+
+// AfterFunc d 之后在新的协程中执行 f，连接断开时自动取消
+// 返回的任务可通过 Stop 提前取消，连接已断开时返回 nil
+func (this *Connection) AfterFunc(d time.Duration, f func()) *utils.Timer {
+	this.timerLocker.Lock()
+	defer this.timerLocker.Unlock()
+	if this.timersStopped || this.IsClose() {
+		return nil
+	}
+	if this.tasks == nil {
+		this.tasks = map[*utils.Timer]struct{}{}
+	}
+	var t *utils.Timer
+	t = timeWheel.AfterFunc(d, func() {
+		this.timerLocker.Lock()
+		delete(this.tasks, t)
+		this.timerLocker.Unlock()
+		if this.IsClose() {
+			return
+		}
+		f()
+	})
+	this.tasks[t] = struct{}{}
+	return t
+}
+
+// 断开时取消所有定时任务
+func (this *Connection) stopTimers() {
+	this.timerLocker.Lock()
+	defer this.timerLocker.Unlock()
+	this.timersStopped = true
+	for _, t := range this.timers {
+		t.Stop()
+	}
+	for t := range this.tasks {
+		t.Stop()
+	}
+	this.timers = nil
+	this.tasks = nil
+}
+
+// 写超时，UDP 服务端的会话共享同一个 socket，不设置写超时
+func (this *Connection) writeTimeout() time.Duration {
+	if this.options == nil || this.isUdp && !this.isClient {
+		return 0
+	}
+	return this.options.WriteTimeout
+}
@@ -0,0 +1,152 @@
+package libnet
+
+import (
+	"errors"
+	"github.com/1uLang/libnet/options"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// 启动服务并连接，返回服务端的处理器及客户端连接
+func dialServe(t *testing.T, opts ...options.Option) (*testHandler, *Serve, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &testHandler{}
+	svr := NewServe("", handler, opts...)
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		_ = svr.Close()
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return svr.Count() == 1
+	})
+	return handler, svr, conn
+}
+
+func TestConnection_ReadTimeout(t *testing.T) {
+	for _, opt := range []options.Option{options.WithReadTimeout(100 * time.Millisecond), options.WithTimeout(100 * time.Millisecond)} {
+		handler, svr, conn := dialServe(t, opt)
+		waitFor(t, func() bool {
+			return len(handler.closeReasons()) == 1
+		})
+		if reasons := handler.closeReasons(); reasons[0] != CloseReadTimeout {
+			t.Fatal("unexpected close reasons:", reasons)
+		}
+		_ = conn.Close()
+		_ = svr.Close()
+	}
+}
+
+func TestConnection_IdleTimeout(t *testing.T) {
+	handler, svr, conn := dialServe(t, options.WithIdleTimeout(150*time.Millisecond))
+	defer svr.Close()
+	defer conn.Close()
+
+	// 持续收发数据时不断开
+	buf := make([]byte, 4)
+	for i := 0; i < 6; i++ {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if svr.Count() != 1 {
+		t.Fatal("active connection closed:", handler.closeReasons())
+	}
+	waitFor(t, func() bool {
+		return len(handler.closeReasons()) == 1
+	})
+	if reasons := handler.closeReasons(); reasons[0] != CloseIdle {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+}
+
+func TestConnection_WriteTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	handler := &testHandler{}
+	opts := options.GetOptions(options.WithWriteTimeout(50 * time.Millisecond))
+	c := newConnection(server, nil, handler, opts, false, false)
+
+	// 对端不读取，写入阻塞直到超时
+	_, err := c.Write([]byte("hello"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("expect write timeout, got", err)
+	}
+	if reasons := handler.closeReasons(); len(reasons) != 1 || reasons[0] != CloseWriteTimeout {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+}
+
+func TestServe_WriteQueueTimeout(t *testing.T) {
+	handler, svr, conn := dialServe(t,
+		options.WithWriteBuffer(4096),
+		options.WithWriteQueue(0, 1<<20, options.OverflowDrop),
+		options.WithWriteTimeout(100*time.Millisecond))
+	defer svr.Close()
+	defer conn.Close()
+	var c *Connection
+	svr.Range(func(item *Connection) bool {
+		c = item
+		return false
+	})
+
+	// 对端不读取，发送协程等待可写通知直到超时
+	chunk := make([]byte, 64*1024)
+	for i := 0; i < 32 && !c.IsClose(); i++ {
+		_, _ = c.Write(chunk)
+	}
+	waitFor(t, func() bool {
+		return len(handler.closeReasons()) == 1
+	})
+	if reasons := handler.closeReasons(); reasons[0] != CloseWriteTimeout {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+}
+
+func TestConnection_AfterFunc(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newConnection(server, nil, nil, nil, false, false)
+
+	fired := make(chan string, 3)
+	c.AfterFunc(20*time.Millisecond, func() {
+		fired <- "first"
+	})
+	c.AfterFunc(20*time.Millisecond, func() {
+		fired <- "stopped"
+	}).Stop()
+	c.AfterFunc(200*time.Millisecond, func() {
+		fired <- "closed"
+	})
+	select {
+	case name := <-fired:
+		if name != "first" {
+			t.Fatal("unexpected task:", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task not fired")
+	}
+
+	// 断开后取消未执行的任务
+	_ = c.Close("done")
+	if c.AfterFunc(time.Millisecond, func() {}) != nil {
+		t.Fatal("task added after close")
+	}
+	select {
+	case name := <-fired:
+		t.Fatal("unexpected task:", name)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
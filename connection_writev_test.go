@@ -0,0 +1,99 @@
+package libnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/1uLang/libnet/options"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 消息头与数据分开编码的测试消息
+type frameMessage struct {
+	data []byte
+}
+
+func (m *frameMessage) header() []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(m.data)))
+	return header
+}
+
+func (m *frameMessage) Marshal() []byte          { return append(m.header(), m.data...) }
+func (m *frameMessage) MarshalBuffers() [][]byte { return [][]byte{m.header(), m.data} }
+func (m *frameMessage) MsgId() uint64            { return 0 }
+func (m *frameMessage) HeaderLength() uint32     { return 4 }
+func (m *frameMessage) GetLength() uint32        { return uint32(len(m.data)) }
+func (m *frameMessage) SetData(buf []byte)       { m.data = buf }
+
+func TestConnection_Writev(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newConnection(server, nil, nil, nil, false, false)
+	defer c.Close("done")
+
+	bufs := [][]byte{[]byte("hello"), []byte(", "), []byte("world")}
+	go func() {
+		_, _ = c.Writev(bufs)
+		_, _ = c.WriteMessage(&frameMessage{data: []byte("msg")})
+	}()
+	buf := make([]byte, len("hello, world")+4+3)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:12]) != "hello, world" || !bytes.Equal(buf[12:], []byte{0, 0, 0, 3, 'm', 's', 'g'}) {
+		t.Fatal("unexpected data:", buf)
+	}
+	if string(bufs[0]) != "hello" || len(bufs) != 3 {
+		t.Fatal("caller buffers modified")
+	}
+}
+
+func TestServe_WriteBatch(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{},
+		options.WithWriteQueue(0, 1<<20, options.OverflowBlock),
+		options.WithWriteBatch(64))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool {
+		return svr.Count() == 1
+	})
+	var c *Connection
+	svr.Range(func(item *Connection) bool {
+		c = item
+		return false
+	})
+
+	const count = 2000
+	go func() {
+		for i := 0; i < count; i++ {
+			data := make([]byte, 4)
+			binary.BigEndian.PutUint32(data, uint32(i))
+			_, _ = c.WriteMessage(&frameMessage{data: data})
+		}
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 8)
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint32(buf) != 4 || binary.BigEndian.Uint32(buf[4:]) != uint32(i) {
+			t.Fatal("unexpected frame at", i, buf)
+		}
+	}
+}
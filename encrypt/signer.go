@@ -0,0 +1,95 @@
+package encrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+	"hash"
+)
+
+// Signer 签名及验签
+type Signer interface {
+	// 签名
+	Sign(data []byte) (sign []byte, err error)
+
+	// 验签
+	Verify(data, sign []byte) bool
+}
+
+// HMACSigner HMAC 签名，收发双方共享密钥
+type HMACSigner struct {
+	hash func() hash.Hash
+	key  []byte
+}
+
+// NewHMACSHA256Signer HMAC-SHA256 签名
+func NewHMACSHA256Signer(key []byte) (*HMACSigner, error) {
+	return newHMACSigner(sha256.New, key)
+}
+
+// NewHMACSM3Signer HMAC-SM3 签名
+func NewHMACSM3Signer(key []byte) (*HMACSigner, error) {
+	return newHMACSigner(sm3.New, key)
+}
+
+func newHMACSigner(h func() hash.Hash, key []byte) (*HMACSigner, error) {
+	if len(key) == 0 {
+		return nil, errors.New("hmac key is empty")
+	}
+	return &HMACSigner{hash: h, key: append([]byte(nil), key...)}, nil
+}
+
+func (this *HMACSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(this.hash, this.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (this *HMACSigner) Verify(data, sign []byte) bool {
+	expected, _ := this.Sign(data)
+	return hmac.Equal(expected, sign)
+}
+
+// GMSM2Signer SM2 签名，私钥签名、公钥验签，使用默认的用户ID
+type GMSM2Signer struct {
+	pri *sm2.PrivateKey
+	pub *sm2.PublicKey
+}
+
+// NewGMSM2Signer 以原始字节格式的密钥（与 gm-sm2-ecc 相同）创建签名，
+// 只验签时 pri 可为空，只签名时 pub 可为空（由私钥计算）
+func NewGMSM2Signer(pri, pub []byte) (*GMSM2Signer, error) {
+	this := &GMSM2Signer{}
+	if len(pri) > 0 {
+		key, err := sm2.RawBytesToPrivateKey(pri)
+		if err != nil {
+			return nil, err
+		}
+		this.pri = key
+		this.pub = sm2.CalculatePubKey(key)
+	}
+	if len(pub) > 0 {
+		key, err := sm2.RawBytesToPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		this.pub = key
+	}
+	if this.pub == nil {
+		return nil, errors.New("sm2 key is empty")
+	}
+	return this, nil
+}
+
+func (this *GMSM2Signer) Sign(data []byte) ([]byte, error) {
+	if this.pri == nil {
+		return nil, errors.New("sm2 private key is empty")
+	}
+	return sm2.Sign(this.pri, nil, data)
+}
+
+func (this *GMSM2Signer) Verify(data, sign []byte) bool {
+	return sm2.Verify(this.pub, nil, data, sign)
+}
@@ -0,0 +1,62 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"github.com/ZZMarquis/gm/sm2"
+	"testing"
+)
+
+func TestHMACSigner(t *testing.T) {
+	for _, newSigner := range []func([]byte) (*HMACSigner, error){NewHMACSHA256Signer, NewHMACSM3Signer} {
+		signer, err := newSigner([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte("Hello, World")
+		sign, err := signer.Sign(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !signer.Verify(data, sign) {
+			t.Fatal("verify failed")
+		}
+		other, _ := newSigner([]byte("other"))
+		if other.Verify(data, sign) || signer.Verify([]byte("Hello, world"), sign) {
+			t.Fatal("verify should fail")
+		}
+	}
+	if _, err := NewHMACSHA256Signer(nil); err == nil {
+		t.Fatal("empty key should fail")
+	}
+}
+
+func TestGMSM2Signer(t *testing.T) {
+	pri, pub, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewGMSM2Signer(pri.GetRawBytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("Hello, World")
+	sign, err := signer.Sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只持有公钥的一方验签
+	verifier, err := NewGMSM2Signer(nil, pub.GetRawBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifier.Verify(data, sign) {
+		t.Fatal("verify failed")
+	}
+	if verifier.Verify([]byte("Hello, world"), sign) || verifier.Verify(data, sign[1:]) {
+		t.Fatal("verify should fail")
+	}
+	if _, err := verifier.Sign(data); err == nil {
+		t.Fatal("sign without private key should fail")
+	}
+}
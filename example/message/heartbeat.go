@@ -0,0 +1,27 @@
+package message
+
+import "github.com/1uLang/libnet/message"
+
+// KeepaliveCodec 以 KeepaliveRequestCode 消息实现心跳，双方在空闲时互发心跳消息，收到心跳消息无需响应
+type KeepaliveCodec struct{}
+
+// Ping 构建心跳消息
+func (KeepaliveCodec) Ping() message.MessageI {
+	return &Message{Type: KeepaliveRequestCode}
+}
+
+// IsPing 是否为心跳消息
+func (KeepaliveCodec) IsPing(msg message.MessageI) bool {
+	m, ok := msg.(*Message)
+	return ok && m.Type == KeepaliveRequestCode
+}
+
+// Pong 心跳消息无需响应
+func (KeepaliveCodec) Pong(ping message.MessageI) message.MessageI {
+	return nil
+}
+
+// IsPong 心跳消息无需响应
+func (KeepaliveCodec) IsPong(msg message.MessageI) bool {
+	return false
+}
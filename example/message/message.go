@@ -58,6 +58,16 @@ type Message struct {
 
 // Marshal 编码消息
 func (this *Message) Marshal() []byte {
+	return append(this.marshalHeader(), this.Data...)
+}
+
+// MarshalBuffers 分开编码消息头与数据，避免拼接
+func (this *Message) MarshalBuffers() [][]byte {
+	return [][]byte{this.marshalHeader(), this.Data}
+}
+
+// 编码消息头
+func (this *Message) marshalHeader() []byte {
 
 	if this.Id <= 0 {
 		this.Id = atomic.AddUint64(&messageId, 1)
@@ -66,23 +76,18 @@ func (this *Message) Marshal() []byte {
 	if this.Version == 0 {
 		this.Version = MessageVersion
 	}
-	result := []byte{this.Version}
+	result := make([]byte, MessageHeaderLength, MessageHeaderLength+len(this.Data))
+	result[0] = this.Version
 
 	// ID
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, this.Id)
-	result = append(result, buf...)
+	binary.BigEndian.PutUint64(result[MessageIdIndex:], this.Id)
 
 	// Type
-	result = append(result, this.Type)
+	result[MessageTypeIndex] = this.Type
 
 	// Length
 	this.Length = uint32(len(this.Data))
-	binary.BigEndian.PutUint32(buf, this.Length)
-	result = append(result, buf[:4]...)
-
-	// Data
-	result = append(result, this.Data...)
+	binary.BigEndian.PutUint32(result[MessageLengthIndex:], this.Length)
 	return result
 }
 
@@ -16,7 +16,7 @@ type conn struct {
 func (c *conn) onMessage(m message.MessageI) {
 	msg := m.(*message2.Message)
 	msg.SetData([]byte("recv msg"))
-	_, _ = c.c.Write(msg.Marshal())
+	_, _ = c.c.WriteMessage(msg)
 }
 
 // OnConnect 当TCP长连接建立成功是回调
@@ -6,6 +6,5 @@ require (
 	github.com/ZZMarquis/gm v1.3.2
 	github.com/mailru/easygo v0.0.0-20190618140210-3c14a0dc985f
 	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/sys v0.0.0-20221013171732-95e765b1cc43
 )
-
-require golang.org/x/sys v0.0.0-20221013171732-95e765b1cc43 // indirect
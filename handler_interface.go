@@ -1,5 +1,7 @@
 package libnet
 
+import "net"
+
 // 处理连接事件的回调接口
 
 type Handler interface {
@@ -7,3 +9,39 @@ type Handler interface {
 	OnMessage(c *Connection, bytes []byte) // 新消息回调
 	OnClose(c *Connection, msg string)     // 连接断开回调
 }
+
+// 连接被拒绝的原因
+const (
+	RejectMaxConnections      = "max connections"        // 超过最大连接数
+	RejectMaxConnectionsPerIP = "max connections per ip" // 超过单IP最大连接数
+	RejectACL                 = "acl denied"             // 访问控制列表拒绝
+	RejectProxyProtocol       = "invalid proxy protocol" // PROXY protocol 头部错误
+	RejectProxyPending        = "proxy protocol pending" // 等待读取 PROXY protocol 头部的连接过多
+	RejectSPA                 = "spa denied"             // 未通过单包授权
+	RejectNoAddress           = "no source address"      // 数据报没有来源地址（未绑定地址的 unixgram 对端）
+)
+
+// 连接超时断开的原因
+const (
+	CloseReadTimeout  = "read timeout"  // 读超时
+	CloseWriteTimeout = "write timeout" // 写超时
+	CloseIdle         = "idle"          // 空闲超时
+
+	CloseHeartbeatTimeout = "heartbeat timeout" // 心跳超时
+)
+
+// 敲门服务以流式服务运行时收到数据即断开的原因，敲门只接受数据报
+const CloseSPAStream = "spa knock over stream"
+
+// RejectHandler 连接或数据报被拒绝回调接口，Handler 可选实现
+// 在接受连接和读取数据报的循环中同步调用，应尽快返回
+type RejectHandler interface {
+	OnReject(addr net.Addr, reason string) // 被拒绝回调，调用后连接将被关闭、数据报将被丢弃；RejectNoAddress 时 addr 为 nil
+}
+
+// PacketHandler 数据报回调接口，Handler 可选实现
+// 实现后数据报服务不再按对端地址建立会话，解密后的数据报在读取循环中同步回调，应尽快返回；
+// 不占用连接数，不回调 OnConnect 及 OnClose，data 在回调返回后被复用
+type PacketHandler interface {
+	OnPacket(addr net.Addr, data []byte) // 新数据报回调
+}
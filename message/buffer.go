@@ -20,6 +20,7 @@ type Buffer struct {
 
 	onMessage  func(msg MessageI)
 	onError    func(err error)
+	intercept  func(msg MessageI) bool
 	parserFunc func([]byte) (MessageI, error)
 	hasError   bool
 }
@@ -81,7 +82,7 @@ func (this *Buffer) Write(rawBuf []byte) {
 		this.msgId = msg.MsgId()
 		if msg.GetLength() == 0 {
 			if this.onMessage != nil {
-				this.onMessage(msg)
+				this.deliver(msg)
 				// 由于onMessage可能会改变buffer，所以这里需要做判断
 				if len(this.buf) == int(msg.HeaderLength()) {
 					this.buf = nil
@@ -100,7 +101,7 @@ func (this *Buffer) Write(rawBuf []byte) {
 			this.buf = this.writeBytes(this.buf)
 		} else {
 			if this.msg != nil && this.onMessage != nil {
-				this.onMessage(this.msg)
+				this.deliver(this.msg)
 			}
 		}
 	}
@@ -110,6 +111,11 @@ func (this *Buffer) OnMessage(f func(msg MessageI)) {
 	this.onMessage = f
 }
 
+// Intercept 设置消息拦截函数，返回 true 的消息不再交由 OnMessage 处理，用于心跳等内部消息
+func (this *Buffer) Intercept(f func(msg MessageI) bool) {
+	this.intercept = f
+}
+
 func (this *Buffer) OnError(f func(err error)) {
 	this.onError = f
 }
@@ -130,7 +136,7 @@ func (this *Buffer) writeBytes(buf []byte) []byte {
 		if this.msg != nil && this.onMessage != nil {
 			this.msg.SetData(buf)
 			if this.msgLen == 0 {
-				this.onMessage(this.msg)
+				this.deliver(this.msg)
 			}
 		}
 
@@ -140,7 +146,7 @@ func (this *Buffer) writeBytes(buf []byte) []byte {
 	// if l > msgLen
 	if this.msg != nil && this.onMessage != nil {
 		this.msg.SetData(buf[:this.msgLen])
-		this.onMessage(this.msg)
+		this.deliver(this.msg)
 	}
 
 	buf = buf[this.msgLen:]
@@ -148,3 +154,11 @@ func (this *Buffer) writeBytes(buf []byte) []byte {
 
 	return buf
 }
+
+// 交由拦截函数及 OnMessage 处理
+func (this *Buffer) deliver(msg MessageI) {
+	if this.intercept != nil && this.intercept(msg) {
+		return
+	}
+	this.onMessage(msg)
+}
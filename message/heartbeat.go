@@ -0,0 +1,10 @@
+package message
+
+// HeartbeatCodec 心跳消息编解码，用于在自定义消息格式上实现心跳
+// 收到的心跳消息由连接处理，不再交由 Buffer 的 OnMessage 处理
+type HeartbeatCodec interface {
+	Ping() MessageI              // 构建心跳请求
+	IsPing(msg MessageI) bool    // 是否为心跳请求
+	Pong(ping MessageI) MessageI // 构建心跳响应，返回 nil 表示不响应
+	IsPong(msg MessageI) bool    // 是否为心跳响应
+}
@@ -10,3 +10,8 @@ type MessageI interface {
 type ParseI interface {
 	CheckHeader([]byte) (MessageI, error)
 }
+
+// BuffersMarshaler 消息头与数据分开编码，发送时无需拼接，由 Connection.WriteMessage 使用 writev 发送
+type BuffersMarshaler interface {
+	MarshalBuffers() [][]byte
+}
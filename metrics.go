@@ -0,0 +1,50 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/metrics"
+	"github.com/1uLang/libnet/workers"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// 注册于 metrics.Default 的服务指标
+var (
+	metricAccepts       = metrics.Default.NewCounter("libnet_accepts_total", "Total number of connections accepted after access control and connection limits.")
+	metricRejects       = metrics.Default.NewCounterVec("libnet_rejects_total", "Total number of rejected connections.", "reason")
+	metricBytesRead     = metrics.Default.NewCounter("libnet_read_bytes_total", "Total number of bytes read.")
+	metricBytesWritten  = metrics.Default.NewCounter("libnet_written_bytes_total", "Total number of bytes written.")
+	metricDecryptErrors = metrics.Default.NewCounter("libnet_decrypt_errors_total", "Total number of failed decryptions.")
+	metricHandler       = metrics.Default.NewHistogramVec("libnet_handler_duration_seconds", "Handler callback latency in seconds.", nil, "callback")
+
+	metricOnConnect = metricHandler.WithLabelValues("on_connect")
+	metricOnMessage = metricHandler.WithLabelValues("on_message")
+	metricOnClose   = metricHandler.WithLabelValues("on_close")
+)
+
+func init() {
+	metrics.Default.NewGaugeFunc("libnet_active_connections", "Number of active connections.", func() float64 {
+		return float64(atomic.LoadInt64(&countConnections))
+	})
+	metrics.Default.NewGaugeFunc("libnet_worker_queue_depth", "Number of tasks waiting in connection workers.", func() float64 {
+		return float64(workers.QueueDepth())
+	})
+	metrics.Default.NewCounterFunc("libnet_byte_pool_hits_total", "Total number of buffers reused from the byte pool.", func() float64 {
+		hits, _ := bytePool.Stats()
+		return float64(hits)
+	})
+	metrics.Default.NewCounterFunc("libnet_byte_pool_misses_total", "Total number of buffers allocated by the byte pool.", func() float64 {
+		_, misses := bytePool.Stats()
+		return float64(misses)
+	})
+}
+
+// MetricsHandler 以 Prometheus 文本格式输出服务指标
+func MetricsHandler() http.Handler {
+	return metrics.Handler()
+}
+
+// 记录回调耗时
+func observe(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
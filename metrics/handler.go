@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType Prometheus 文本格式
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler 以 Prometheus 文本格式输出默认注册表中的指标
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Handler 以 Prometheus 文本格式输出注册表中的指标
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// WriteTo 以 Prometheus 文本格式写入注册表中的指标
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.locker.RLock()
+	metrics := append([]metric{}, r.metrics...)
+	r.locker.RUnlock()
+
+	w := &writer{w: bufio.NewWriter(out)}
+	for _, m := range metrics {
+		m.write(w)
+	}
+	if err := w.w.Flush(); err != nil {
+		return w.n, err
+	}
+	return w.n, nil
+}
+
+// 文本格式输出
+type writer struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (w *writer) write(s string) {
+	n, _ := w.w.WriteString(s)
+	w.n += int64(n)
+}
+
+func (w *writer) header(name, help, typ string) {
+	w.write("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.write("# TYPE " + name + " " + typ + "\n")
+}
+
+func (w *writer) sample(name, pairs string, value float64) {
+	if pairs != "" {
+		name += "{" + pairs + "}"
+	}
+	w.write(name + " " + formatFloat(value) + "\n")
+}
+
+func joinPairs(pairs, pair string) string {
+	if pairs == "" {
+		return pair
+	}
+	return pairs + "," + pair
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,325 @@
+// Package metrics 计数器、仪表及直方图指标，以 Prometheus 文本格式输出，仅依赖标准库
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets 默认的直方图分桶，单位为秒
+var DefBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// 单个指标
+type metric interface {
+	write(w *writer)
+}
+
+// Registry 指标注册表
+type Registry struct {
+	locker  sync.RWMutex
+	metrics []metric
+	names   map[string]struct{}
+}
+
+// Default 默认注册表，libnet 的服务指标注册于此
+var Default = NewRegistry()
+
+// NewRegistry 创建注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[string]struct{}{},
+	}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if _, ok := r.names[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = struct{}{}
+	r.metrics = append(r.metrics, m)
+}
+
+// 指标描述
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) header(w *writer) {
+	w.header(d.name, d.help, d.typ)
+}
+
+// Counter 计数器，只增不减
+type Counter struct {
+	desc  *desc
+	value atomic.Uint64
+	pairs string // 标签，非向量时为空
+}
+
+// NewCounter 注册计数器
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{desc: &desc{name: name, help: help, typ: "counter"}}
+	r.register(name, c)
+	return c
+}
+
+// Inc 加1
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add 增加 n
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value 当前值
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(w *writer) {
+	c.desc.header(w)
+	c.sample(w)
+}
+
+func (c *Counter) sample(w *writer) {
+	w.sample(c.desc.name, c.pairs, float64(c.value.Load()))
+}
+
+// Gauge 仪表，可增可减
+type Gauge struct {
+	desc  *desc
+	value atomic.Int64
+}
+
+// NewGauge 注册仪表
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: &desc{name: name, help: help, typ: "gauge"}}
+	r.register(name, g)
+	return g
+}
+
+// Set 设置当前值
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Add 增加 n，n 可为负数
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Value 当前值
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+func (g *Gauge) write(w *writer) {
+	g.desc.header(w)
+	w.sample(g.desc.name, "", float64(g.value.Load()))
+}
+
+// 输出时调用函数取值的指标
+type funcMetric struct {
+	desc *desc
+	f    func() float64
+}
+
+// NewGaugeFunc 注册仪表，输出时调用 f 取值
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, &funcMetric{desc: &desc{name: name, help: help, typ: "gauge"}, f: f})
+}
+
+// NewCounterFunc 注册计数器，输出时调用 f 取值，f 的返回值应只增不减
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(name, &funcMetric{desc: &desc{name: name, help: help, typ: "counter"}, f: f})
+}
+
+func (m *funcMetric) write(w *writer) {
+	m.desc.header(w)
+	w.sample(m.desc.name, "", m.f())
+}
+
+// Histogram 直方图
+type Histogram struct {
+	desc    *desc
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     atomic.Uint64 // float64 的位表示
+	pairs   string
+}
+
+// NewHistogram 注册直方图，buckets 为各分桶的上限，为空时使用 DefBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	d := &desc{name: name, help: help, typ: "histogram"}
+	h := newHistogram(d, buckets, "")
+	r.register(name, h)
+	return h
+}
+
+func newHistogram(d *desc, buckets []float64, pairs string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{
+		desc:    d,
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)),
+		pairs:   pairs,
+	}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Count 观测值个数
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+func (h *Histogram) write(w *writer) {
+	h.desc.header(w)
+	h.sample(w)
+}
+
+func (h *Histogram) sample(w *writer) {
+	cumulative := uint64(0)
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i].Load()
+		w.sample(h.desc.name+"_bucket", joinPairs(h.pairs, `le="`+formatFloat(upper)+`"`), float64(cumulative))
+	}
+	count := h.count.Load()
+	w.sample(h.desc.name+"_bucket", joinPairs(h.pairs, `le="+Inf"`), float64(count))
+	w.sample(h.desc.name+"_sum", h.pairs, math.Float64frombits(h.sum.Load()))
+	w.sample(h.desc.name+"_count", h.pairs, float64(count))
+}
+
+// 带标签的指标向量
+type vec[T any] struct {
+	desc     *desc
+	locker   sync.RWMutex
+	children map[string]T
+	keys     []string
+	create   func(pairs string) T
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.desc.labels) {
+		panic("metrics: " + v.desc.name + " label values count mismatch")
+	}
+	key := strings.Join(values, "\xff")
+	v.locker.RLock()
+	child, ok := v.children[key]
+	v.locker.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.locker.Lock()
+	defer v.locker.Unlock()
+	if child, ok = v.children[key]; ok {
+		return child
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.desc.labels[i] + `="` + escapeLabel(value) + `"`
+	}
+	child = v.create(strings.Join(pairs, ","))
+	v.children[key] = child
+	v.keys = append(v.keys, key)
+	sort.Strings(v.keys)
+	return child
+}
+
+func (v *vec[T]) each(f func(T)) {
+	v.locker.RLock()
+	defer v.locker.RUnlock()
+	for _, key := range v.keys {
+		f(v.children[key])
+	}
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	vec[*Counter]
+}
+
+// NewCounterVec 注册带标签的计数器
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	d := &desc{name: name, help: help, typ: "counter", labels: labels}
+	v := &CounterVec{vec[*Counter]{
+		desc:     d,
+		children: map[string]*Counter{},
+		create: func(pairs string) *Counter {
+			return &Counter{desc: d, pairs: pairs}
+		},
+	}}
+	r.register(name, v)
+	return v
+}
+
+// WithLabelValues 按标签值取得计数器，标签值的顺序与注册时一致
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.with(values)
+}
+
+func (v *CounterVec) write(w *writer) {
+	v.desc.header(w)
+	v.each(func(c *Counter) {
+		c.sample(w)
+	})
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+// NewHistogramVec 注册带标签的直方图，buckets 为空时使用 DefBuckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	d := &desc{name: name, help: help, typ: "histogram", labels: labels}
+	v := &HistogramVec{vec[*Histogram]{
+		desc:     d,
+		children: map[string]*Histogram{},
+		create: func(pairs string) *Histogram {
+			return newHistogram(d, buckets, pairs)
+		},
+	}}
+	r.register(name, v)
+	return v
+}
+
+// WithLabelValues 按标签值取得直方图，标签值的顺序与注册时一致
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.with(values)
+}
+
+func (v *HistogramVec) write(w *writer) {
+	v.desc.header(w)
+	v.each(func(h *Histogram) {
+		h.sample(w)
+	})
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("test_total", "Test counter.")
+	counter.Add(2)
+	counter.Inc()
+	gauge := r.NewGauge("test_gauge", "Test gauge.")
+	gauge.Set(5)
+	gauge.Add(-2)
+	r.NewGaugeFunc("test_func", "Test func.", func() float64 { return 1.5 })
+	vec := r.NewCounterVec("test_labels_total", "Test \\ help\nline.", "reason")
+	vec.WithLabelValues(`b"`).Inc()
+	vec.WithLabelValues("a").Add(2)
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	buf := &bytes.Buffer{}
+	if _, err := r.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 3
+# HELP test_func Test func.
+# TYPE test_func gauge
+test_func 1.5
+# HELP test_labels_total Test \\ help\nline.
+# TYPE test_labels_total counter
+test_labels_total{reason="a"} 2
+test_labels_total{reason="b\""} 1
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	r.NewGauge("dup_total", "")
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	vec := r.NewHistogramVec("test_seconds", "Test histogram.", nil, "callback")
+	vec.WithLabelValues("on_message").Observe(0.002)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Header().Get("Content-Type") != ContentType {
+		t.Fatal("unexpected content type:", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`test_seconds_bucket{callback="on_message",le="0.001"} 0`,
+		`test_seconds_bucket{callback="on_message",le="0.005"} 1`,
+		`test_seconds_bucket{callback="on_message",le="+Inf"} 1`,
+		`test_seconds_count{callback="on_message"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/acl"
+	"github.com/1uLang/libnet/options"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	accepts, read, decryptErrors := metricAccepts.Value(), metricBytesRead.Value(), metricDecryptErrors.Value()
+	messages := metricOnMessage.Count()
+
+	_, svr, conn := dialServe(t, options.WithEncryptMethod(badMethod{}))
+	defer svr.Close()
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("bad"))
+	waitFor(t, func() bool {
+		return metricDecryptErrors.Value() == decryptErrors+1
+	})
+	if metricAccepts.Value() != accepts+1 || metricBytesRead.Value() != read+8 || metricOnMessage.Count() != messages+1 {
+		t.Fatal("unexpected metrics:", metricAccepts.Value()-accepts, metricBytesRead.Value()-read, metricOnMessage.Count()-messages)
+	}
+
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, name := range []string{
+		"libnet_accepts_total ",
+		"libnet_read_bytes_total ",
+		"libnet_written_bytes_total ",
+		"libnet_decrypt_errors_total ",
+		"libnet_active_connections ",
+		"libnet_worker_queue_depth ",
+		"libnet_byte_pool_hits_total ",
+		"libnet_byte_pool_misses_total ",
+		`libnet_handler_duration_seconds_count{callback="on_message"} `,
+	} {
+		if !strings.Contains(body, "\n"+name) {
+			t.Fatalf("missing %q in:\n%s", name, body)
+		}
+	}
+}
+
+// 被拒绝的连接只计入 libnet_rejects_total
+func TestMetricsRejects(t *testing.T) {
+	rules, err := acl.New(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []options.Option{options.WithACL(rules), options.WithProxyProtocol(true)} {
+		accepts := metricAccepts.Value()
+		rejects := metricRejects.WithLabelValues(RejectACL).Value() + metricRejects.WithLabelValues(RejectProxyProtocol).Value()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		svr := NewServe("", &testHandler{}, opt)
+		go func() {
+			_ = svr.ServeListener(ln)
+		}()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		waitFor(t, func() bool {
+			return metricRejects.WithLabelValues(RejectACL).Value()+metricRejects.WithLabelValues(RejectProxyProtocol).Value() == rejects+1
+		})
+		if metricAccepts.Value() != accepts {
+			t.Fatal("rejected connection counted as accepted")
+		}
+		_ = conn.Close()
+		_ = svr.Close()
+	}
+}
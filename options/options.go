@@ -1,18 +1,61 @@
 package options
 
 import (
+	"github.com/1uLang/libnet/acl"
 	"github.com/1uLang/libnet/encrypt"
+	"github.com/1uLang/libnet/message"
+	"github.com/1uLang/libnet/spa"
 	"time"
 )
 
 // Options Server初始化参数
 type Options struct {
 	EncryptMethod encrypt.MethodInterface // 数据加解密算法
-	Timeout       time.Duration           // 连接读写超时时间
+	Timeout       time.Duration           // 连接读超时时间，未设置 ReadTimeout 时使用；同时作为客户端的拨号超时
 	PrivateKey    []byte                  // 加解密算法私钥
 	PublicKey     []byte                  // 加解密算法公钥
+	ReusePort     int                     // SO_REUSEPORT 监听数量，0 表示不开启
+
+	// 超时，0 表示不检查
+	ReadTimeout  time.Duration // 读超时，超过该时间未读取到数据时断开
+	WriteTimeout time.Duration // 写超时，单次发送超过该时间未完成时断开
+	IdleTimeout  time.Duration // 空闲超时，超过该时间未读取也未发送数据时断开
+
+	// 心跳，间隔为0表示不开启
+	HeartbeatInterval time.Duration          // 超过该时间未发送数据时发送心跳请求
+	HeartbeatTimeout  time.Duration          // 超过该时间未收到任何数据时断开
+	HeartbeatCodec    message.HeartbeatCodec // 心跳消息编解码
+
+	MaxConnections      int // 最大连接数，0 表示不限制
+	MaxConnectionsPerIP int // 单IP最大连接数，0 表示不限制
+
+	ACL           *acl.ACL  // IP访问控制列表
+	SPA           *spa.Gate // 单包授权，未敲门的地址被拒绝
+	ProxyProtocol bool      // 是否解析 PROXY protocol 头部
+
+	// socket 参数，零值表示使用系统默认值
+	TCPKeepAlive time.Duration // TCP keepalive 间隔，小于0表示关闭
+	NoDelay      *bool         // TCP_NODELAY
+	Linger       *int          // SO_LINGER 秒数，小于0表示系统默认行为
+	ReadBuffer   int           // SO_RCVBUF
+	WriteBuffer  int           // SO_SNDBUF
+
+	// 异步发送队列，消息数及字节数均为0表示不开启
+	WriteQueueMessages int            // 发送队列最大消息数，0 表示不限制
+	WriteQueueBytes    int            // 发送队列最大字节数，0 表示不限制
+	WriteQueuePolicy   OverflowPolicy // 发送队列溢出策略
+	WriteBatch         int            // 发送协程单次合并发送的最大消息数，0 表示不合并
 }
 
+// OverflowPolicy 发送队列溢出策略
+type OverflowPolicy int
+
+const (
+	OverflowBlock OverflowPolicy = iota // 阻塞写入方直到队列有空闲
+	OverflowDrop                        // 丢弃新写入的消息
+	OverflowClose                       // 断开连接
+)
+
 type Option interface {
 	apply(*Options)
 }
@@ -48,6 +91,64 @@ func WithTimeout(timeout time.Duration) Option {
 	})
 }
 
+// WithReadTimeout 设置读超时，超过该时间未读取到数据时以 "read timeout" 断开连接
+func WithReadTimeout(timeout time.Duration) Option {
+	return newFuncServerOption(func(o *Options) {
+		if timeout < 0 {
+			panic("readTimeout must greater than or equal 0")
+		}
+		o.ReadTimeout = timeout
+	})
+}
+
+// WithWriteTimeout 设置写超时，单次发送超过该时间未完成时以 "write timeout" 断开连接
+func WithWriteTimeout(timeout time.Duration) Option {
+	return newFuncServerOption(func(o *Options) {
+		if timeout < 0 {
+			panic("writeTimeout must greater than or equal 0")
+		}
+		o.WriteTimeout = timeout
+	})
+}
+
+// WithIdleTimeout 设置空闲超时，超过该时间既未读取也未发送数据时以 "idle" 断开连接
+func WithIdleTimeout(timeout time.Duration) Option {
+	return newFuncServerOption(func(o *Options) {
+		if timeout < 0 {
+			panic("idleTimeout must greater than or equal 0")
+		}
+		o.IdleTimeout = timeout
+	})
+}
+
+// WithHeartbeat 开启心跳，连接超过 interval 未发送数据时发送 codec 构建的心跳请求，
+// 超过 timeout 未收到心跳响应或任何数据时以 "heartbeat timeout" 断开连接
+// 连接设置了 message.Buffer 时自动响应对端的心跳请求
+func WithHeartbeat(interval, timeout time.Duration, codec message.HeartbeatCodec) Option {
+	return newFuncServerOption(func(o *Options) {
+		if interval <= 0 {
+			panic("heartbeat interval must greater than 0")
+		}
+		if timeout <= 0 {
+			panic("heartbeat timeout must greater than 0")
+		}
+		if codec == nil {
+			panic("heartbeat codec not be nil")
+		}
+		o.HeartbeatInterval = interval
+		o.HeartbeatTimeout = timeout
+		o.HeartbeatCodec = codec
+	})
+}
+
+// GetReadTimeout 读超时，未设置 ReadTimeout 时使用 Timeout
+func (o *Options) GetReadTimeout() time.Duration {
+	if o.ReadTimeout > 0 {
+		return o.ReadTimeout
+	}
+	return o.Timeout
+}
+
 // WithPrivateKey 设置加解密私钥
 func WithPrivateKey(privateKey []byte) Option {
 	return newFuncServerOption(func(o *Options) {
@@ -68,6 +169,131 @@ func WithPublicKey(publicKey []byte) Option {
 	})
 }
 
+// WithReusePort 开启 SO_REUSEPORT，同一地址打开 n 个监听，由内核在各监听间均衡连接
+func WithReusePort(n int) Option {
+	return newFuncServerOption(func(o *Options) {
+		if n < 0 {
+			panic("reusePort must greater than or equal 0")
+		}
+		o.ReusePort = n
+	})
+}
+
+// WithMaxConnections 设置服务最大连接数，超出的连接将被拒绝
+func WithMaxConnections(max int) Option {
+	return newFuncServerOption(func(o *Options) {
+		if max < 0 {
+			panic("maxConnections must greater than or equal 0")
+		}
+		o.MaxConnections = max
+	})
+}
+
+// WithMaxConnectionsPerIP 设置单IP最大连接数，超出的连接将被拒绝
+func WithMaxConnectionsPerIP(max int) Option {
+	return newFuncServerOption(func(o *Options) {
+		if max < 0 {
+			panic("maxConnectionsPerIP must greater than or equal 0")
+		}
+		o.MaxConnectionsPerIP = max
+	})
+}
+
+// WithACL 设置IP访问控制列表，未被允许的连接和数据报在 OnConnect 之前被丢弃
+// 可通过 acl.ACL.Reload 在运行时替换规则
+func WithACL(a *acl.ACL) Option {
+	return newFuncServerOption(func(o *Options) {
+		o.ACL = a
+	})
+}
+
+// WithSPA 开启单包授权，仅放行期内的来源IP可以建立连接，其余连接在握手及 OnConnect 之前被拒绝
+// 敲门数据报由另一个以 libnet.NewSPAHandler 运行的 UDP 服务接收
+func WithSPA(g *spa.Gate) Option {
+	return newFuncServerOption(func(o *Options) {
+		o.SPA = g
+	})
+}
+
+// WithProxyProtocol 开启 PROXY protocol v1/v2 解析，用于部署在 HAProxy/LVS 等代理之后
+// 开启后所有 TCP/TLS 连接必须以合法的 PROXY 头部开始，否则连接将被拒绝；访问控制及连接数限制以头部中的真实来源地址进行
+func WithProxyProtocol(enable bool) Option {
+	return newFuncServerOption(func(o *Options) {
+		o.ProxyProtocol = enable
+	})
+}
+
+// WithTCPKeepAlive 设置 TCP keepalive 探测间隔，小于0表示关闭 keepalive
+func WithTCPKeepAlive(period time.Duration) Option {
+	return newFuncServerOption(func(o *Options) {
+		o.TCPKeepAlive = period
+	})
+}
+
+// WithNoDelay 设置是否关闭 Nagle 算法（TCP_NODELAY）
+func WithNoDelay(noDelay bool) Option {
+	return newFuncServerOption(func(o *Options) {
+		o.NoDelay = &noDelay
+	})
+}
+
+// WithLinger 设置连接关闭时的 SO_LINGER 秒数，0 表示关闭时直接丢弃未发送数据并发送 RST
+func WithLinger(sec int) Option {
+	return newFuncServerOption(func(o *Options) {
+		o.Linger = &sec
+	})
+}
+
+// WithReadBuffer 设置 socket 接收缓冲区大小
+func WithReadBuffer(size int) Option {
+	return newFuncServerOption(func(o *Options) {
+		if size < 0 {
+			panic("readBuffer must greater than or equal 0")
+		}
+		o.ReadBuffer = size
+	})
+}
+
+// WithWriteBuffer 设置 socket 发送缓冲区大小
+func WithWriteBuffer(size int) Option {
+	return newFuncServerOption(func(o *Options) {
+		if size < 0 {
+			panic("writeBuffer must greater than or equal 0")
+		}
+		o.WriteBuffer = size
+	})
+}
+
+// WithWriteQueue 开启异步发送队列，Write 将消息放入队列后立即返回，由独立的协程按序发送
+// messages、bytes 为队列的消息数及字节数上限（0 表示不限制，不能同时为0），超出上限时按 policy 处理
+func WithWriteQueue(messages, bytes int, policy OverflowPolicy) Option {
+	return newFuncServerOption(func(o *Options) {
+		if messages < 0 || bytes < 0 {
+			panic("writeQueue limit must greater than or equal 0")
+		}
+		if messages == 0 && bytes == 0 {
+			panic("writeQueue limit must be set")
+		}
+		if policy < OverflowBlock || policy > OverflowClose {
+			panic("writeQueue policy is invalid")
+		}
+		o.WriteQueueMessages = messages
+		o.WriteQueueBytes = bytes
+		o.WriteQueuePolicy = policy
+	})
+}
+
+// WithWriteBatch 开启合并发送，发送协程将队列中至多 n 条消息合并为一次 writev 发送，减少高频小消息的系统调用次数
+// 需同时通过 WithWriteQueue 开启发送队列
+func WithWriteBatch(n int) Option {
+	return newFuncServerOption(func(o *Options) {
+		if n < 0 {
+			panic("writeBatch must greater than or equal 0")
+		}
+		o.WriteBatch = n
+	})
+}
+
 func GetOptions(opts ...Option) *Options {
 	options := &Options{}
 
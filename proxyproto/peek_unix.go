@@ -0,0 +1,37 @@
+//go:build !windows
+
+package proxyproto
+
+import (
+	"io"
+	"syscall"
+)
+
+// 窥视 socket 中已到达的数据而不取出，阻塞直到有数据可读或超过读超时
+// r 不是 socket 时返回 0
+func peek(r io.Reader, p []byte) (int, error) {
+	conn, ok := r.(syscall.Conn)
+	if !ok {
+		return 0, nil
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, nil
+	}
+	var n int
+	var peekErr error
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, _, peekErr = syscall.Recvfrom(int(fd), p, syscall.MSG_PEEK)
+		return peekErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+	if peekErr != nil {
+		return 0, peekErr
+	}
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
@@ -0,0 +1,8 @@
+package proxyproto
+
+import "io"
+
+// Windows 下不窥视，逐字节读取
+func peek(r io.Reader, p []byte) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,319 @@
+// Package proxyproto 解析 HAProxy PROXY protocol v1/v2 头部
+// 参考 https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107 // v1 头部最大长度，包含 \r\n
+
+	v2HeaderLength = 16
+	v2AddrInet     = 12
+	v2AddrInet6    = 36
+	v2AddrUnix     = 216
+)
+
+// v2 头部签名
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// 命令
+const (
+	CommandLocal = 0x0 // 代理自身发起的连接（如健康检查），不携带地址
+	CommandProxy = 0x1 // 代理转发的连接
+)
+
+// 传输协议
+const (
+	TransportUnspec = 0x0
+	TransportStream = 0x1
+	TransportDgram  = 0x2
+)
+
+// 地址族
+const (
+	FamilyUnspec = 0x0
+	FamilyInet   = 0x1
+	FamilyInet6  = 0x2
+	FamilyUnix   = 0x3
+)
+
+var (
+	ErrInvalidSignature = errors.New("proxyproto: invalid signature")
+	ErrInvalidHeader    = errors.New("proxyproto: invalid header")
+	ErrHeaderTooLong    = errors.New("proxyproto: v1 header too long")
+	ErrUnsupported      = errors.New("proxyproto: unsupported version or command")
+)
+
+// TLV v2 头部的扩展字段
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header PROXY protocol 头部
+type Header struct {
+	Version         byte     // 1 或 2
+	Command         byte     // CommandLocal / CommandProxy，v1 始终为 CommandProxy
+	Family          byte     // 地址族
+	Transport       byte     // 传输协议
+	SourceAddr      net.Addr // 真实来源地址，LOCAL 或 UNKNOWN 时为 nil
+	DestinationAddr net.Addr // 真实目标地址，LOCAL 或 UNKNOWN 时为 nil
+	TLVs            []TLV    // v2 扩展字段
+}
+
+// TLV 取得指定类型的扩展字段
+func (this *Header) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range this.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ReadHeader 从 r 中读取并解析 PROXY protocol 头部
+// 只读取头部字节，不会多读后续的应用数据，读取后 r 可直接交给其他读取方
+func ReadHeader(r io.Reader) (*Header, error) {
+	// v1 最短头部 "PROXY UNKNOWN\r\n" 为15字节，v2 头部为16字节，先读8字节区分版本
+	buf := make([]byte, 8, v1MaxLength)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(buf, []byte(v1Prefix)) {
+		return readV1(r, buf)
+	}
+	if bytes.Equal(buf, v2Signature[:8]) {
+		return readV2(r, buf)
+	}
+	return nil, ErrInvalidSignature
+}
+
+// 读取 v1 头部直到 \r\n
+// r 为 socket 时先窥视已到达的数据，一次取出到 \r\n 为止的字节，否则逐字节读取
+func readV1(r io.Reader, buf []byte) (*Header, error) {
+	for !bytes.HasSuffix(buf, []byte("\r\n")) {
+		if len(buf) >= v1MaxLength {
+			return nil, ErrHeaderTooLong
+		}
+		n := 1
+		peeked, err := peek(r, buf[len(buf):v1MaxLength])
+		if err != nil {
+			return nil, err
+		}
+		if peeked > 0 {
+			n = peeked
+			// \r\n 可能跨越两次读取，从已读取的最后一个字节开始查找
+			if i := bytes.Index(buf[len(buf)-1:len(buf)+peeked], []byte("\r\n")); i >= 0 {
+				n = i + 1
+			}
+		}
+		buf = buf[:len(buf)+n]
+		_, err = io.ReadFull(r, buf[len(buf)-n:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseV1(string(buf[len(v1Prefix) : len(buf)-2]))
+}
+
+// 解析 v1 头部 "TCP4 src dst sport dport"
+func parseV1(line string) (*Header, error) {
+	fields := strings.Split(line, " ")
+	header := &Header{
+		Version:   1,
+		Command:   CommandProxy,
+		Transport: TransportStream,
+	}
+	switch fields[0] {
+	case "UNKNOWN":
+		return header, nil
+	case "TCP4":
+		header.Family = FamilyInet
+	case "TCP6":
+		header.Family = FamilyInet6
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if len(fields) != 5 {
+		return nil, ErrInvalidHeader
+	}
+	src, err := parseV1Addr(fields[1], fields[3], header.Family)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[2], fields[4], header.Family)
+	if err != nil {
+		return nil, err
+	}
+	header.SourceAddr = src
+	header.DestinationAddr = dst
+	return header, nil
+}
+
+func parseV1Addr(ip, port string, family byte) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || strings.Contains(ip, ":") != (family == FamilyInet6) {
+		return nil, ErrInvalidHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// 读取 v2 头部
+func readV2(r io.Reader, buf []byte) (*Header, error) {
+	buf = buf[:v2HeaderLength]
+	_, err := io.ReadFull(r, buf[8:])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:12], v2Signature) {
+		return nil, ErrInvalidSignature
+	}
+	if buf[12]>>4 != 2 {
+		return nil, ErrUnsupported
+	}
+	header := &Header{
+		Version:   2,
+		Command:   buf[12] & 0xf,
+		Family:    buf[13] >> 4,
+		Transport: buf[13] & 0xf,
+	}
+	if header.Command != CommandLocal && header.Command != CommandProxy {
+		return nil, ErrUnsupported
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// 地址
+	var addrLength int
+	switch header.Family {
+	case FamilyUnspec:
+	case FamilyInet:
+		addrLength = v2AddrInet
+	case FamilyInet6:
+		addrLength = v2AddrInet6
+	case FamilyUnix:
+		addrLength = v2AddrUnix
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if len(payload) < addrLength {
+		return nil, ErrInvalidHeader
+	}
+	if header.Command == CommandProxy {
+		header.SourceAddr, header.DestinationAddr = parseV2Addr(payload[:addrLength], header.Family, header.Transport)
+	}
+
+	// TLV
+	tlvs := payload[addrLength:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, ErrInvalidHeader
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, ErrInvalidHeader
+		}
+		header.TLVs = append(header.TLVs, TLV{
+			Type:  tlvs[0],
+			Value: tlvs[3 : 3+l],
+		})
+		tlvs = tlvs[3+l:]
+	}
+	return header, nil
+}
+
+func parseV2Addr(buf []byte, family, transport byte) (src, dst net.Addr) {
+	switch family {
+	case FamilyInet, FamilyInet6:
+		size := net.IPv4len
+		if family == FamilyInet6 {
+			size = net.IPv6len
+		}
+		srcIP := net.IP(append([]byte{}, buf[:size]...))
+		dstIP := net.IP(append([]byte{}, buf[size:2*size]...))
+		srcPort := int(binary.BigEndian.Uint16(buf[2*size:]))
+		dstPort := int(binary.BigEndian.Uint16(buf[2*size+2:]))
+		if transport == TransportDgram {
+			return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		}
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+	case FamilyUnix:
+		network := "unix"
+		if transport == TransportDgram {
+			network = "unixgram"
+		}
+		return &net.UnixAddr{Name: unixName(buf[:108]), Net: network}, &net.UnixAddr{Name: unixName(buf[108:216]), Net: network}
+	}
+	return nil, nil
+}
+
+func unixName(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
+
+// Marshal 编码头部，用于客户端或测试
+// v1 仅支持 TCP4/TCP6/UNKNOWN，v2 支持 LOCAL 及 IPv4/IPv6 地址
+func (this *Header) Marshal() ([]byte, error) {
+	src, srcOk := this.SourceAddr.(*net.TCPAddr)
+	dst, dstOk := this.DestinationAddr.(*net.TCPAddr)
+	if this.Version == 1 {
+		if !srcOk || !dstOk {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP4"
+		if src.IP.To4() == nil {
+			proto = "TCP6"
+		}
+		return []byte(v1Prefix + proto + " " + src.IP.String() + " " + dst.IP.String() + " " +
+			strconv.Itoa(src.Port) + " " + strconv.Itoa(dst.Port) + "\r\n"), nil
+	}
+	if this.Version != 2 {
+		return nil, ErrUnsupported
+	}
+
+	buf := append([]byte{}, v2Signature...)
+	buf = append(buf, 0x20|this.Command, 0, 0, 0)
+	if this.Command == CommandProxy {
+		if !srcOk || !dstOk {
+			return nil, ErrUnsupported
+		}
+		srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+		family := byte(FamilyInet)
+		if srcIP == nil || dstIP == nil {
+			srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+			family = FamilyInet6
+		}
+		buf[13] = family<<4 | TransportStream
+		buf = append(buf, srcIP...)
+		buf = append(buf, dstIP...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(src.Port))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(dst.Port))
+	}
+	for _, tlv := range this.TLVs {
+		buf = append(buf, tlv.Type)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(tlv.Value)))
+		buf = append(buf, tlv.Value...)
+	}
+	binary.BigEndian.PutUint16(buf[14:16], uint16(len(buf)-v2HeaderLength))
+	return buf, nil
+}
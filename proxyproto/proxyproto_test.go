@@ -0,0 +1,173 @@
+package proxyproto
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadHeader_V1(t *testing.T) {
+	r := bytes.NewReader([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n"))
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 1 || header.SourceAddr.String() != "192.168.0.1:56324" || header.DestinationAddr.String() != "192.168.0.11:443" {
+		t.Fatal("unexpected header:", header)
+	}
+	// 头部之后的数据不应被读取
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Fatal("unexpected rest:", string(rest))
+	}
+
+	header, err = ReadHeader(bytes.NewReader([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.SourceAddr.String() != "[2001:db8::1]:1234" {
+		t.Fatal("unexpected source:", header.SourceAddr)
+	}
+
+	header, err = ReadHeader(bytes.NewReader([]byte("PROXY UNKNOWN\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.SourceAddr != nil {
+		t.Fatal("unknown header should not carry address")
+	}
+}
+
+func TestReadHeader_V1Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",
+		"PROXY TCP4 2001:db8::1 192.168.0.11 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 056324 443\r\n",
+		"PROXY SCTP 192.168.0.1 192.168.0.11 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443" + string(bytes.Repeat([]byte(" "), 100)) + "\r\n",
+		"GET / HTTP/1.1\r\n\r\n",
+	} {
+		if _, err := ReadHeader(bytes.NewReader([]byte(raw))); err == nil {
+			t.Fatal("expect error:", raw)
+		}
+	}
+}
+
+// 从 socket 读取时窥视已到达的数据，\r\n 跨越两次到达时也不多读
+func TestReadHeader_V1Socket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, parts := range [][]string{
+		{"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello"},
+		{"PROXY TCP4 192.168.0.1 ", "192.168.0.11 56324 443\r", "\nhello"},
+	} {
+		go func(parts []string) {
+			for _, part := range parts {
+				_, _ = client.Write([]byte(part))
+				time.Sleep(20 * time.Millisecond)
+			}
+		}(parts)
+		header, err := ReadHeader(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.SourceAddr.String() != "192.168.0.1:56324" {
+			t.Fatal("unexpected source:", header.SourceAddr)
+		}
+		rest := make([]byte, 5)
+		if _, err := io.ReadFull(conn, rest); err != nil || string(rest) != "hello" {
+			t.Fatal("unexpected rest:", string(rest), err)
+		}
+	}
+}
+
+func TestReadHeader_V2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 1000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2").To4(), Port: 2000}
+	raw, err := (&Header{
+		Version:         2,
+		Command:         CommandProxy,
+		SourceAddr:      src,
+		DestinationAddr: dst,
+		TLVs:            []TLV{{Type: 0x04, Value: []byte("vpc-1")}},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(append(raw, "data"...))
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.SourceAddr.String() != src.String() || header.DestinationAddr.String() != dst.String() {
+		t.Fatal("unexpected header:", header)
+	}
+	if value, ok := header.TLV(0x04); !ok || string(value) != "vpc-1" {
+		t.Fatal("unexpected tlv:", header.TLVs)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "data" {
+		t.Fatal("unexpected rest:", string(rest))
+	}
+
+	// IPv6
+	raw, _ = (&Header{
+		Version:         2,
+		Command:         CommandProxy,
+		SourceAddr:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1},
+		DestinationAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2},
+	}).Marshal()
+	header, err = ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Family != FamilyInet6 || header.SourceAddr.String() != "[2001:db8::1]:1" {
+		t.Fatal("unexpected header:", header)
+	}
+
+	// LOCAL
+	raw, _ = (&Header{Version: 2, Command: CommandLocal}).Marshal()
+	header, err = ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Command != CommandLocal || header.SourceAddr != nil {
+		t.Fatal("unexpected header:", header)
+	}
+}
+
+func TestReadHeader_V2Invalid(t *testing.T) {
+	raw, _ := (&Header{
+		Version:         2,
+		Command:         CommandProxy,
+		SourceAddr:      &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1},
+		DestinationAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2},
+	}).Marshal()
+
+	badVersion := append([]byte{}, raw...)
+	badVersion[12] = 0x31
+	truncated := raw[:len(raw)-2]
+	badLength := append([]byte{}, raw...)
+	badLength[15] = 4
+	for _, b := range [][]byte{badVersion, truncated, badLength} {
+		if _, err := ReadHeader(bytes.NewReader(b)); err == nil {
+			t.Fatal("expect error")
+		}
+	}
+}
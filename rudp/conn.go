@@ -0,0 +1,682 @@
+package rudp
+
+import (
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 初始重传超时，收到首个 RTT 样本前使用
+const initialRTO = 200 * time.Millisecond
+
+// 本端关闭后等待 FIN 被确认的最长时间
+const finTimeout = 3 * time.Second
+
+// 连续收到多少个后续包的确认时快速重传
+const fastResend = 3
+
+// 数据包
+type segment struct {
+	seq      uint32
+	data     []byte
+	fin      bool
+	xmit     int // 发送次数
+	sentAt   time.Time
+	resendAt time.Time
+	rto      time.Duration
+	sacked   bool // 已被选择确认
+	fast     bool // 已快速重传
+}
+
+// Conn 可靠有序的 UDP 连接，实现 net.Conn
+type Conn struct {
+	ep     *endpoint
+	conv   uint32
+	remote net.Addr
+	cfg    Config
+
+	locker sync.Mutex
+
+	// 发送
+	sndNext    uint32     // 下一个待分配的序号
+	sndBuf     []*segment // 已发送未确认，按序号排列
+	sndQueue   []*segment // 待发送
+	rwnd       int        // 对端接收窗口
+	cwnd       float64    // 拥塞窗口
+	ssthresh   float64    // 慢启动阈值
+	inRecovery bool       // 快速恢复中，直到 recover 之前的包均被确认
+	recover    uint32
+	srtt       time.Duration
+	rttvar     time.Duration
+	rto        time.Duration
+	probeAt    time.Time // 对端接收窗口为0时下次探测的时间
+	finQueued  bool
+	finAcked   bool
+
+	// 接收
+	rcvNext    uint32              // 期望接收的下一个序号
+	rcvBuf     map[uint32]*segment // 乱序到达的包
+	readBuf    [][]byte            // 已按序到达、等待读取的数据
+	eof        bool                // 已收到 FIN
+	ackPending bool
+	lastWnd    int // 最后通告的接收窗口
+
+	lastSend time.Time
+	lastRecv time.Time
+
+	established bool // 客户端收到服务端的响应后建立
+	synAt       time.Time
+	estCh       chan struct{}
+	closed      bool // 本端已调用 Close
+	closedAt    time.Time
+	err         error // 异常断开的原因
+	done        bool  // 已从 endpoint 移除
+	dieCh       chan struct{}
+	dying       bool
+
+	readable chan struct{}
+	writable chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+
+	out []byte
+}
+
+func newConn(ep *endpoint, conv uint32, remote net.Addr, established bool) *Conn {
+	now := time.Now()
+	this := &Conn{
+		ep:            ep,
+		conv:          conv,
+		remote:        remote,
+		cfg:           ep.cfg,
+		rwnd:          ep.cfg.Window,
+		cwnd:          4,
+		ssthresh:      float64(ep.cfg.Window),
+		rto:           initialRTO,
+		rcvBuf:        map[uint32]*segment{},
+		lastWnd:       ep.cfg.Window,
+		lastSend:      now,
+		lastRecv:      now,
+		established:   established,
+		estCh:         make(chan struct{}),
+		dieCh:         make(chan struct{}),
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		out:           make([]byte, 0, headerSize+ep.cfg.MSS+1+maxSackBlocks*8),
+	}
+	if this.rto < this.cfg.MinRTO {
+		this.rto = this.cfg.MinRTO
+	}
+	if this.rto > this.cfg.MaxRTO {
+		this.rto = this.cfg.MaxRTO
+	}
+	if established {
+		close(this.estCh)
+	}
+	return this
+}
+
+// Read 读取按序到达的数据，对端关闭后返回 io.EOF
+func (this *Conn) Read(b []byte) (int, error) {
+	for {
+		this.locker.Lock()
+		if len(this.readBuf) > 0 {
+			n := 0
+			for n < len(b) && len(this.readBuf) > 0 {
+				m := copy(b[n:], this.readBuf[0])
+				n += m
+				if m < len(this.readBuf[0]) {
+					this.readBuf[0] = this.readBuf[0][m:]
+				} else {
+					this.readBuf[0] = nil
+					this.readBuf = this.readBuf[1:]
+				}
+			}
+			// 接收窗口从较小恢复时通知对端
+			if this.lastWnd < this.cfg.Window/4 && this.window() >= this.cfg.Window/4 {
+				this.ackPending = true
+				this.flush(time.Now())
+			}
+			this.locker.Unlock()
+			return n, nil
+		}
+		err := this.readErr()
+		this.locker.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-this.readable:
+		case <-this.dieCh:
+		case <-this.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// 无数据可读时返回的错误
+func (this *Conn) readErr() error {
+	switch {
+	case this.eof:
+		return io.EOF
+	case this.err != nil:
+		return this.err
+	case this.closed:
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// Write 写入数据，发送队列已满时阻塞
+func (this *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for {
+		this.locker.Lock()
+		if this.closed {
+			this.locker.Unlock()
+			return written, net.ErrClosed
+		}
+		if this.err != nil {
+			this.locker.Unlock()
+			return written, this.err
+		}
+		for len(b) > 0 && len(this.sndQueue) < this.cfg.Window {
+			n := this.queue(b)
+			b = b[n:]
+			written += n
+		}
+		this.flush(time.Now())
+		this.locker.Unlock()
+		if len(b) == 0 {
+			return written, nil
+		}
+		select {
+		case <-this.writable:
+		case <-this.dieCh:
+		case <-this.writeDeadline.wait():
+			return written, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// 加入发送队列，未发送的最后一个包有空余时合并，返回加入的长度
+func (this *Conn) queue(b []byte) int {
+	if n := len(this.sndQueue); n > 0 {
+		last := this.sndQueue[n-1]
+		if !last.fin && len(last.data) < this.cfg.MSS {
+			m := this.cfg.MSS - len(last.data)
+			if m > len(b) {
+				m = len(b)
+			}
+			last.data = append(last.data, b[:m]...)
+			return m
+		}
+	}
+	n := len(b)
+	if n > this.cfg.MSS {
+		n = this.cfg.MSS
+	}
+	data := make([]byte, n, this.cfg.MSS)
+	copy(data, b)
+	this.sndQueue = append(this.sndQueue, &segment{data: data})
+	return n
+}
+
+// Close 关闭连接，已写入的数据及 FIN 在后台继续发送，直到被确认或超时
+func (this *Conn) Close() error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	this.closedAt = time.Now()
+	this.die()
+	if this.err != nil || !this.established {
+		this.finish()
+		return nil
+	}
+	if !this.finQueued {
+		this.finQueued = true
+		this.sndQueue = append(this.sndQueue, &segment{fin: true})
+	}
+	this.flush(this.closedAt)
+	return nil
+}
+
+func (this *Conn) LocalAddr() net.Addr {
+	return this.ep.pc.LocalAddr()
+}
+
+func (this *Conn) RemoteAddr() net.Addr {
+	return this.remote
+}
+
+func (this *Conn) SetDeadline(t time.Time) error {
+	this.readDeadline.set(t)
+	this.writeDeadline.set(t)
+	return nil
+}
+
+func (this *Conn) SetReadDeadline(t time.Time) error {
+	this.readDeadline.set(t)
+	return nil
+}
+
+func (this *Conn) SetWriteDeadline(t time.Time) error {
+	this.writeDeadline.set(t)
+	return nil
+}
+
+// 处理收到的包
+func (this *Conn) input(h header, payload []byte, now time.Time) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if this.done {
+		return
+	}
+	this.lastRecv = now
+	if h.cmd == cmdRst {
+		this.fail(ErrReset)
+		return
+	}
+	if !this.established {
+		this.established = true
+		close(this.estCh)
+	}
+	this.rwnd = int(h.wnd)
+	this.ack(h.una, now)
+	switch h.cmd {
+	case cmdAck:
+		this.sack(parseSacks(payload), now)
+	case cmdData, cmdFin:
+		this.receive(h.seq, payload, h.cmd == cmdFin)
+		this.ackPending = true
+	case cmdSyn, cmdPing:
+		this.ackPending = true
+	}
+	this.flush(now)
+}
+
+// 累计确认，una 之前的包均已被对端收到
+func (this *Conn) ack(una uint32, now time.Time) {
+	if seqBefore(this.sndNext, una) {
+		return
+	}
+	acked := false
+	for len(this.sndBuf) > 0 && seqBefore(this.sndBuf[0].seq, una) {
+		seg := this.sndBuf[0]
+		if !seg.sacked {
+			this.acked(seg, now)
+		}
+		if seg.fin {
+			this.finAcked = true
+		}
+		this.sndBuf[0] = nil
+		this.sndBuf = this.sndBuf[1:]
+		acked = true
+	}
+	if this.inRecovery && !seqBefore(una, this.recover) {
+		this.inRecovery = false
+	}
+	if acked {
+		notify(this.writable)
+	}
+}
+
+// 选择确认
+func (this *Conn) sack(blocks []sackBlock, now time.Time) {
+	for _, b := range blocks {
+		for _, seg := range this.sndBuf {
+			if !seg.sacked && !seqBefore(seg.seq, b.start) && seqBefore(seg.seq, b.end) {
+				seg.sacked = true
+				this.acked(seg, now)
+			}
+		}
+	}
+}
+
+// 包首次被确认，更新 RTT 及拥塞窗口
+func (this *Conn) acked(seg *segment, now time.Time) {
+	// 重传过的包无法区分确认的是哪次发送，不作为 RTT 样本
+	if seg.xmit == 1 {
+		this.updateRTT(now.Sub(seg.sentAt))
+	}
+	if this.cwnd < this.ssthresh {
+		this.cwnd++
+	} else {
+		this.cwnd += 1 / this.cwnd
+	}
+	if this.cwnd > float64(this.cfg.Window) {
+		this.cwnd = float64(this.cfg.Window)
+	}
+}
+
+func (this *Conn) updateRTT(rtt time.Duration) {
+	if this.srtt == 0 {
+		this.srtt = rtt
+		this.rttvar = rtt / 2
+	} else {
+		delta := this.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		this.rttvar = (3*this.rttvar + delta) / 4
+		this.srtt = (7*this.srtt + rtt) / 8
+	}
+	variance := 4 * this.rttvar
+	if variance < this.cfg.Interval {
+		variance = this.cfg.Interval
+	}
+	this.rto = this.srtt + variance
+	if this.rto < this.cfg.MinRTO {
+		this.rto = this.cfg.MinRTO
+	}
+	if this.rto > this.cfg.MaxRTO {
+		this.rto = this.cfg.MaxRTO
+	}
+}
+
+// 接收数据包，按序的数据移入读取缓冲区
+func (this *Conn) receive(seq uint32, payload []byte, fin bool) {
+	if this.eof || seqBefore(seq, this.rcvNext) || !seqBefore(seq, this.rcvNext+uint32(this.cfg.Window)) {
+		return
+	}
+	if _, ok := this.rcvBuf[seq]; ok {
+		return
+	}
+	// 读取缓冲区已满时丢弃，对端稍后重传
+	if seq != this.rcvNext && len(this.rcvBuf)+len(this.readBuf) >= this.cfg.Window {
+		return
+	}
+	this.rcvBuf[seq] = &segment{seq: seq, data: append([]byte(nil), payload...), fin: fin}
+	delivered := false
+	for {
+		seg, ok := this.rcvBuf[this.rcvNext]
+		if !ok {
+			break
+		}
+		delete(this.rcvBuf, this.rcvNext)
+		this.rcvNext++
+		if len(seg.data) > 0 {
+			this.readBuf = append(this.readBuf, seg.data)
+		}
+		delivered = true
+		if seg.fin {
+			this.eof = true
+			this.rcvBuf = map[uint32]*segment{}
+			break
+		}
+	}
+	if delivered {
+		notify(this.readable)
+	}
+}
+
+// 剩余的接收窗口
+func (this *Conn) window() int {
+	wnd := this.cfg.Window - len(this.rcvBuf) - len(this.readBuf)
+	if wnd < 0 {
+		return 0
+	}
+	return wnd
+}
+
+// 发送确认、重传及新数据，检查保活及超时
+func (this *Conn) flush(now time.Time) {
+	if this.done {
+		return
+	}
+	if this.ackPending {
+		this.ackPending = false
+		this.sendAck()
+	}
+	if now.Sub(this.lastRecv) >= this.cfg.Timeout {
+		this.fail(ErrTimeout)
+		return
+	}
+	if !this.established {
+		// 客户端重发 SYN 直到收到响应
+		if now.Sub(this.synAt) >= this.rto {
+			this.synAt = now
+			this.send(header{cmd: cmdSyn}, nil)
+		}
+		return
+	}
+	if !this.retransmit(now) {
+		return
+	}
+
+	// 发送新数据
+	limit := int(this.cwnd)
+	if this.rwnd < limit {
+		limit = this.rwnd
+	}
+	sent := false
+	for len(this.sndBuf) < limit && len(this.sndQueue) > 0 {
+		seg := this.sndQueue[0]
+		this.sndQueue[0] = nil
+		this.sndQueue = this.sndQueue[1:]
+		seg.seq = this.sndNext
+		seg.rto = this.rto
+		this.sndNext++
+		this.sndBuf = append(this.sndBuf, seg)
+		this.sendSegment(seg, now)
+		sent = true
+	}
+	if sent {
+		notify(this.writable)
+	}
+	// 对端接收窗口为0时定期探测
+	if this.rwnd == 0 && len(this.sndBuf) == 0 && len(this.sndQueue) > 0 && !now.Before(this.probeAt) {
+		this.probeAt = now.Add(this.rto)
+		this.send(header{cmd: cmdPing}, nil)
+	}
+	if now.Sub(this.lastSend) >= this.cfg.KeepAlive {
+		this.send(header{cmd: cmdPing}, nil)
+	}
+
+	if this.closed && (this.finAcked && this.eof || now.Sub(this.closedAt) >= finTimeout) {
+		this.finish()
+	}
+}
+
+// 重传超时或被后续包的确认越过的包，返回 false 表示超过最大重传次数已断开
+func (this *Conn) retransmit(now time.Time) bool {
+	sacked := 0
+	for _, seg := range this.sndBuf {
+		if seg.sacked {
+			sacked++
+		}
+	}
+	timeout, fast := false, false
+	for _, seg := range this.sndBuf {
+		if seg.sacked {
+			sacked--
+			continue
+		}
+		if !now.Before(seg.resendAt) {
+			if seg.xmit > this.cfg.MaxRetrans {
+				this.fail(ErrTimeout)
+				return false
+			}
+			seg.rto *= 2
+			if seg.rto > this.cfg.MaxRTO {
+				seg.rto = this.cfg.MaxRTO
+			}
+			this.sendSegment(seg, now)
+			timeout = true
+		} else if sacked >= fastResend && !seg.fast {
+			seg.fast = true
+			this.sendSegment(seg, now)
+			fast = true
+		}
+	}
+	// 超时说明网络拥塞严重，重新慢启动；快速重传时窗口减半，每个窗口只减一次
+	if timeout {
+		this.ssthresh = this.halfWindow(float64(len(this.sndBuf)))
+		this.cwnd = 1
+		this.inRecovery = false
+	} else if fast && !this.inRecovery {
+		this.ssthresh = this.halfWindow(this.cwnd)
+		this.cwnd = this.ssthresh
+		this.inRecovery = true
+		this.recover = this.sndNext
+	}
+	return true
+}
+
+func (this *Conn) halfWindow(wnd float64) float64 {
+	if wnd/2 < 2 {
+		return 2
+	}
+	return wnd / 2
+}
+
+func (this *Conn) sendSegment(seg *segment, now time.Time) {
+	seg.xmit++
+	seg.sentAt = now
+	seg.resendAt = now.Add(seg.rto)
+	cmd := byte(cmdData)
+	if seg.fin {
+		cmd = cmdFin
+	}
+	this.send(header{cmd: cmd, seq: seg.seq}, seg.data)
+}
+
+// 发送确认，携带乱序到达的序号区间
+func (this *Conn) sendAck() {
+	var blocks []sackBlock
+	if len(this.rcvBuf) > 0 {
+		seqs := make([]uint32, 0, len(this.rcvBuf))
+		for seq := range this.rcvBuf {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool {
+			return seqBefore(seqs[i], seqs[j])
+		})
+		for _, seq := range seqs {
+			if n := len(blocks); n > 0 && blocks[n-1].end == seq {
+				blocks[n-1].end++
+				continue
+			}
+			if len(blocks) == maxSackBlocks {
+				break
+			}
+			blocks = append(blocks, sackBlock{start: seq, end: seq + 1})
+		}
+	}
+	this.send(header{cmd: cmdAck}, marshalSacks(nil, blocks))
+}
+
+func (this *Conn) send(h header, payload []byte) {
+	h.conv = this.conv
+	h.una = this.rcvNext
+	this.lastWnd = this.window()
+	h.wnd = uint16(this.lastWnd)
+	this.out = append(h.marshal(this.out[:0]), payload...)
+	this.lastSend = time.Now()
+	_, _ = this.ep.pc.WriteTo(this.out, this.remote)
+}
+
+// 定时检查
+func (this *Conn) tick(now time.Time) {
+	this.locker.Lock()
+	this.flush(now)
+	this.locker.Unlock()
+}
+
+// 异常断开
+func (this *Conn) fail(err error) {
+	if this.err == nil {
+		this.err = err
+	}
+	this.die()
+	this.finish()
+}
+
+// 唤醒等待读写的协程
+func (this *Conn) die() {
+	if !this.dying {
+		this.dying = true
+		close(this.dieCh)
+	}
+}
+
+// 从 endpoint 移除，不再收发
+func (this *Conn) finish() {
+	if this.done {
+		return
+	}
+	this.done = true
+	this.ep.remove(this)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// 读写超时，到期时关闭通道
+type deadline struct {
+	locker *sync.Mutex
+	timer  *time.Timer
+	ch     chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{locker: &sync.Mutex{}, ch: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// 定时器已触发，通道已关闭
+		<-d.ch
+	}
+	d.timer = nil
+	closed := isClosed(d.ch)
+	if t.IsZero() {
+		if closed {
+			d.ch = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.ch = make(chan struct{})
+		}
+		ch := d.ch
+		d.timer = time.AfterFunc(dur, func() {
+			close(ch)
+		})
+		return
+	}
+	if !closed {
+		close(d.ch)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	return d.ch
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,276 @@
+package rudp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// 连接标识，服务端以对端地址及会话ID区分，客户端只有一个对端，仅以会话ID区分
+type connKey struct {
+	addr string
+	conv uint32
+}
+
+// 一个 UDP socket 及其上的所有连接，负责读取分发及定时检查
+type endpoint struct {
+	pc       net.PacketConn
+	cfg      Config
+	listener *Listener // 客户端为 nil
+
+	locker sync.Mutex
+	conns  map[connKey]*Conn
+	closed bool
+	die    chan struct{}
+}
+
+func newEndpoint(pc net.PacketConn, cfg Config) *endpoint {
+	return &endpoint{
+		pc:    pc,
+		cfg:   cfg,
+		conns: map[connKey]*Conn{},
+		die:   make(chan struct{}),
+	}
+}
+
+func (ep *endpoint) start() {
+	go ep.readLoop()
+	go ep.tickLoop()
+}
+
+func (ep *endpoint) key(addr net.Addr, conv uint32) connKey {
+	if ep.listener == nil {
+		return connKey{conv: conv}
+	}
+	return connKey{addr: addr.String(), conv: conv}
+}
+
+func (ep *endpoint) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := ep.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || ep.isClosed() {
+				ep.close()
+				return
+			}
+			continue
+		}
+		h, payload, ok := parseHeader(buf[:n])
+		if !ok {
+			continue
+		}
+		key := ep.key(addr, h.conv)
+		ep.locker.Lock()
+		c := ep.conns[key]
+		if c == nil && h.cmd == cmdSyn && ep.listener != nil && !ep.listener.closed && !ep.closed {
+			c = newConn(ep, h.conv, addr, true)
+			select {
+			case ep.listener.accept <- c:
+				ep.conns[key] = c
+			default:
+				// 等待 Accept 的连接已满，丢弃 SYN，由客户端重发
+				c = nil
+			}
+		}
+		ep.locker.Unlock()
+		if c == nil {
+			// 连接不存在，通知对端重置
+			if h.cmd != cmdRst && h.cmd != cmdSyn {
+				rst := header{conv: h.conv, cmd: cmdRst}
+				_, _ = ep.pc.WriteTo(rst.marshal(nil), addr)
+			}
+			continue
+		}
+		c.input(h, payload, time.Now())
+	}
+}
+
+func (ep *endpoint) tickLoop() {
+	ticker := time.NewTicker(ep.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ep.die:
+			return
+		case now := <-ticker.C:
+			for _, c := range ep.snapshot() {
+				c.tick(now)
+			}
+		}
+	}
+}
+
+func (ep *endpoint) snapshot() []*Conn {
+	ep.locker.Lock()
+	defer ep.locker.Unlock()
+	conns := make([]*Conn, 0, len(ep.conns))
+	for _, c := range ep.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// 连接结束，客户端的 socket 随连接关闭，服务端的 socket 在监听关闭且连接全部结束后关闭
+func (ep *endpoint) remove(c *Conn) {
+	ep.locker.Lock()
+	key := ep.key(c.remote, c.conv)
+	if ep.conns[key] == c {
+		delete(ep.conns, key)
+	}
+	idle := ep.listener == nil || ep.listener.closed && len(ep.conns) == 0
+	ep.locker.Unlock()
+	if idle {
+		_ = ep.close()
+	}
+}
+
+func (ep *endpoint) isClosed() bool {
+	ep.locker.Lock()
+	defer ep.locker.Unlock()
+	return ep.closed
+}
+
+// 关闭 socket，其上的连接以 net.ErrClosed 断开
+func (ep *endpoint) close() error {
+	ep.locker.Lock()
+	if ep.closed {
+		ep.locker.Unlock()
+		return nil
+	}
+	ep.closed = true
+	close(ep.die)
+	ep.locker.Unlock()
+	err := ep.pc.Close()
+	for _, c := range ep.snapshot() {
+		c.locker.Lock()
+		c.fail(net.ErrClosed)
+		c.locker.Unlock()
+	}
+	return err
+}
+
+// Listener 可靠 UDP 服务端，实现 net.Listener
+type Listener struct {
+	ep     *endpoint
+	accept chan *Conn
+	closed bool // 由 ep.locker 保护
+	die    chan struct{}
+}
+
+// Listen 监听 UDP 地址
+func Listen(network, address string, config *Config) (*Listener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(pc, config), nil
+}
+
+// NewListener 使用调用方提供的数据报连接创建服务端，关闭 Listener 时关闭 pc
+func NewListener(pc net.PacketConn, config *Config) *Listener {
+	cfg := config.withDefaults()
+	ep := newEndpoint(pc, cfg)
+	this := &Listener{
+		ep:     ep,
+		accept: make(chan *Conn, cfg.AcceptQueue),
+		die:    make(chan struct{}),
+	}
+	ep.listener = this
+	ep.start()
+	return this
+}
+
+// Accept 等待新连接
+func (this *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-this.accept:
+		return c, nil
+	case <-this.die:
+		return nil, net.ErrClosed
+	case <-this.ep.die:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止接受新连接，已接受的连接不受影响，全部结束后关闭 socket
+func (this *Listener) Close() error {
+	this.ep.locker.Lock()
+	if this.closed {
+		this.ep.locker.Unlock()
+		return nil
+	}
+	this.closed = true
+	close(this.die)
+	this.ep.locker.Unlock()
+	// 关闭未被 Accept 的连接
+	for {
+		select {
+		case c := <-this.accept:
+			_ = c.Close()
+		default:
+			this.ep.locker.Lock()
+			idle := len(this.ep.conns) == 0
+			this.ep.locker.Unlock()
+			if idle {
+				return this.ep.close()
+			}
+			return nil
+		}
+	}
+}
+
+func (this *Listener) Addr() net.Addr {
+	return this.ep.pc.LocalAddr()
+}
+
+// Dial 连接可靠 UDP 服务端，timeout 为等待服务端响应的时间，为0时使用 Config.Timeout
+func Dial(network, address string, timeout time.Duration, config *Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Client(pc, raddr, timeout, config)
+}
+
+// Client 使用调用方提供的数据报连接连接服务端，连接关闭时关闭 pc
+func Client(pc net.PacketConn, raddr net.Addr, timeout time.Duration, config *Config) (*Conn, error) {
+	cfg := config.withDefaults()
+	if timeout <= 0 {
+		timeout = cfg.Timeout
+	}
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	ep := newEndpoint(pc, cfg)
+	c := newConn(ep, binary.BigEndian.Uint32(b[:]), raddr, false)
+	ep.conns[ep.key(raddr, c.conv)] = c
+	ep.start()
+	c.tick(time.Now())
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.estCh:
+		return c, nil
+	case <-c.dieCh:
+	case <-timer.C:
+	}
+	c.locker.Lock()
+	err := c.err
+	c.fail(ErrTimeout)
+	c.locker.Unlock()
+	if err == nil {
+		err = ErrTimeout
+	}
+	return nil, err
+}
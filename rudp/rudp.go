@@ -0,0 +1,156 @@
+// Package rudp 基于 UDP 的可靠有序传输
+// 以序号及选择确认（SACK）实现自动重传，按 RTT 估算重传超时，以拥塞窗口及对端接收窗口控制发送速率，
+// 空闲时发送保活包。Conn 实现 net.Conn，Listener 实现 net.Listener，可直接交由 libnet 按流式连接处理
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// 包类型
+const (
+	cmdSyn  = 1 // 建立连接
+	cmdData = 2 // 数据
+	cmdFin  = 3 // 数据发送完毕，与数据一样按序号可靠传输
+	cmdAck  = 4 // 确认
+	cmdPing = 5 // 保活，对端回复确认
+	cmdRst  = 6 // 连接不存在
+)
+
+// 包头
+// [4字节会话ID][1字节类型][2字节接收窗口][4字节 una][4字节序号]
+// una 为期望接收的下一个序号，之前的均已收到；确认包在包头之后携带 [1字节块数][块数*(4字节起始序号+4字节结束序号)]
+const headerSize = 15
+
+// 确认包携带的最大 SACK 块数
+const maxSackBlocks = 8
+
+// 单个数据报的最大长度
+const maxPacketSize = 65535
+
+var (
+	ErrTimeout = errors.New("rudp: peer not responding")
+	ErrReset   = errors.New("rudp: connection reset by peer")
+)
+
+// Config 传输参数，零值字段使用默认值
+type Config struct {
+	MSS         int           // 单个数据包的最大数据长度，默认 1200
+	Window      int           // 收发窗口的包数，默认 256
+	MinRTO      time.Duration // 最小重传超时，默认 50ms
+	MaxRTO      time.Duration // 最大重传超时，默认 5s
+	MaxRetrans  int           // 单个包的最大重传次数，超过后断开，默认 16
+	KeepAlive   time.Duration // 空闲时发送保活包的间隔，默认 5s
+	Timeout     time.Duration // 未收到对端任何数据的超时时间，超时后断开，默认 30s
+	Interval    time.Duration // 检查重传及保活的间隔，默认 10ms
+	AcceptQueue int           // 等待 Accept 的连接数，默认 128
+}
+
+func (c *Config) withDefaults() Config {
+	var cfg Config
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.MSS <= 0 {
+		cfg.MSS = 1200
+	}
+	if cfg.MSS > maxPacketSize-headerSize {
+		cfg.MSS = maxPacketSize - headerSize
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 256
+	}
+	if cfg.Window > 65535 {
+		cfg.Window = 65535
+	}
+	if cfg.MinRTO <= 0 {
+		cfg.MinRTO = 50 * time.Millisecond
+	}
+	if cfg.MaxRTO <= 0 {
+		cfg.MaxRTO = 5 * time.Second
+	}
+	if cfg.MaxRetrans <= 0 {
+		cfg.MaxRetrans = 16
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Millisecond
+	}
+	if cfg.AcceptQueue <= 0 {
+		cfg.AcceptQueue = 128
+	}
+	return cfg
+}
+
+// 包头
+type header struct {
+	conv uint32
+	cmd  byte
+	wnd  uint16
+	una  uint32
+	seq  uint32
+}
+
+func (h *header) marshal(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, h.conv)
+	buf = append(buf, h.cmd)
+	buf = binary.BigEndian.AppendUint16(buf, h.wnd)
+	buf = binary.BigEndian.AppendUint32(buf, h.una)
+	return binary.BigEndian.AppendUint32(buf, h.seq)
+}
+
+func parseHeader(buf []byte) (h header, payload []byte, ok bool) {
+	if len(buf) < headerSize {
+		return h, nil, false
+	}
+	h.conv = binary.BigEndian.Uint32(buf)
+	h.cmd = buf[4]
+	h.wnd = binary.BigEndian.Uint16(buf[5:])
+	h.una = binary.BigEndian.Uint32(buf[7:])
+	h.seq = binary.BigEndian.Uint32(buf[11:])
+	return h, buf[headerSize:], true
+}
+
+// 已收到的序号区间 [start, end)
+type sackBlock struct {
+	start uint32
+	end   uint32
+}
+
+func marshalSacks(buf []byte, blocks []sackBlock) []byte {
+	buf = append(buf, byte(len(blocks)))
+	for _, b := range blocks {
+		buf = binary.BigEndian.AppendUint32(buf, b.start)
+		buf = binary.BigEndian.AppendUint32(buf, b.end)
+	}
+	return buf
+}
+
+func parseSacks(buf []byte) []sackBlock {
+	if len(buf) < 1 {
+		return nil
+	}
+	n := int(buf[0])
+	buf = buf[1:]
+	if n > maxSackBlocks || len(buf) < n*8 {
+		return nil
+	}
+	blocks := make([]sackBlock, n)
+	for i := range blocks {
+		blocks[i].start = binary.BigEndian.Uint32(buf[i*8:])
+		blocks[i].end = binary.BigEndian.Uint32(buf[i*8+4:])
+	}
+	return blocks
+}
+
+// 序号比较，处理回绕
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
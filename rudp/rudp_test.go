@@ -0,0 +1,249 @@
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 按比例丢弃发送的数据报
+type lossyConn struct {
+	net.PacketConn
+	locker  sync.Mutex
+	rand    *rand.Rand
+	rate    float64
+	dropped atomic.Int64
+}
+
+func newLossyConn(t *testing.T, rate float64, seed int64) *lossyConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lossyConn{PacketConn: pc, rand: rand.New(rand.NewSource(seed)), rate: rate}
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.locker.Lock()
+	drop := c.rand.Float64() < c.rate
+	c.locker.Unlock()
+	if drop {
+		c.dropped.Add(1)
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+var testConfig = &Config{MinRTO: 20 * time.Millisecond, KeepAlive: 50 * time.Millisecond, Timeout: 2 * time.Second}
+
+// 建立经过丢包的连接，服务端回显收到的数据
+func dialLossy(t *testing.T, rate float64) (*Conn, *Listener, *lossyConn, *lossyConn) {
+	serverConn := newLossyConn(t, rate, 1)
+	clientConn := newLossyConn(t, rate, 2)
+	ln := NewListener(serverConn, testConfig)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+	c, err := Client(clientConn, ln.Addr(), 3*time.Second, testConfig)
+	if err != nil {
+		_ = ln.Close()
+		t.Fatal(err)
+	}
+	return c, ln, serverConn, clientConn
+}
+
+func TestConn_Transfer(t *testing.T) {
+	for _, rate := range []float64{0, 0.1, 0.2} {
+		c, ln, serverConn, clientConn := dialLossy(t, rate)
+
+		data := make([]byte, 256*1024)
+		rand.New(rand.NewSource(3)).Read(data)
+		go func() {
+			for i := 0; i < len(data); i += 1000 {
+				end := i + 1000
+				if end > len(data) {
+					end = len(data)
+				}
+				if _, err := c.Write(data[i:end]); err != nil {
+					return
+				}
+			}
+		}()
+		received := make([]byte, len(data))
+		_ = c.SetReadDeadline(time.Now().Add(20 * time.Second))
+		if _, err := io.ReadFull(c, received); err != nil {
+			t.Fatal(rate, err)
+		}
+		if !bytes.Equal(received, data) {
+			t.Fatal(rate, "data mismatch")
+		}
+		if rate > 0 && (serverConn.dropped.Load() == 0 || clientConn.dropped.Load() == 0) {
+			t.Fatal(rate, "no packet dropped")
+		}
+
+		// 客户端关闭后服务端读取到 EOF 并关闭，客户端随后读取到 EOF
+		_ = c.SetReadDeadline(time.Time{})
+		_ = c.Close()
+		if _, err := c.Write([]byte("closed")); !errors.Is(err, net.ErrClosed) {
+			t.Fatal("unexpected write error:", err)
+		}
+		_ = ln.Close()
+	}
+}
+
+func TestConn_EOF(t *testing.T) {
+	accepted := make(chan net.Conn, 1)
+	ln := NewListener(newLossyConn(t, 0.2, 4), testConfig)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	client, err := Client(newLossyConn(t, 0.2, 5), ln.Addr(), 3*time.Second, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	_, _ = client.Write([]byte("bye"))
+	_ = client.Close()
+
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	all, err := io.ReadAll(server)
+	if err != nil || string(all) != "bye" {
+		t.Fatal("unexpected read:", string(all), err)
+	}
+}
+
+func TestConn_Timeout(t *testing.T) {
+	serverConn := newLossyConn(t, 0, 1)
+	ln := NewListener(serverConn, &Config{KeepAlive: 20 * time.Millisecond, Timeout: 200 * time.Millisecond})
+	defer ln.Close()
+	clientConn := newLossyConn(t, 0, 2)
+	c, err := Client(clientConn, ln.Addr(), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 读取超时
+	_ = server.SetReadDeadline(time.Now().Add(30 * time.Millisecond))
+	if _, err := server.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("unexpected read error:", err)
+	}
+	_ = server.SetReadDeadline(time.Time{})
+
+	// 客户端不再响应，服务端保活超时后断开
+	_ = clientConn.PacketConn.Close()
+	start := time.Now()
+	if _, err := server.Read(make([]byte, 1)); err != ErrTimeout {
+		t.Fatal("unexpected read error:", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("timeout too late:", elapsed)
+	}
+}
+
+func TestConn_Reset(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	// 响应 SYN 后以 RST 响应其他包，模拟服务端已丢失连接
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			h, _, ok := parseHeader(buf[:n])
+			if !ok {
+				continue
+			}
+			reply := header{conv: h.conv, cmd: cmdRst}
+			if h.cmd == cmdSyn {
+				reply = header{conv: h.conv, cmd: cmdAck, wnd: 16}
+			}
+			_, _ = pc.WriteTo(marshalSacks(reply.marshal(nil), nil), addr)
+		}
+	}()
+
+	c, err := Dial("udp", pc.LocalAddr().String(), time.Second, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, _ = c.Write([]byte("hello"))
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != ErrReset {
+		t.Fatal("unexpected read error:", err)
+	}
+}
+
+func TestDial_Timeout(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	start := time.Now()
+	if _, err := Dial("udp", pc.LocalAddr().String(), 100*time.Millisecond, nil); err != ErrTimeout {
+		t.Fatal("unexpected dial error:", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("dial timeout too late")
+	}
+}
+
+func TestListener_Close(t *testing.T) {
+	c, ln, _, _ := dialLossy(t, 0)
+	echo := func() {
+		_, _ = c.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Fatal("unexpected read:", string(buf), err)
+		}
+	}
+	echo()
+	_ = ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal("unexpected accept error:", err)
+	}
+	if _, err := Dial("udp", ln.Addr().String(), 100*time.Millisecond, nil); err != ErrTimeout {
+		t.Fatal("unexpected dial error:", err)
+	}
+
+	// 已接受的连接不受影响，全部结束后关闭 socket
+	echo()
+	_ = c.Close()
+	for i := 0; !ln.ep.isClosed(); i++ {
+		if i > 100 {
+			t.Fatal("listener socket not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,11 +1,30 @@
 package libnet
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/proxyproto"
+	"github.com/1uLang/libnet/rudp"
 	"github.com/1uLang/libnet/utils"
 	log "github.com/sirupsen/logrus"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 优雅关闭时轮询连接是否全部断开的间隔
+	shutdownPollInterval = 50 * time.Millisecond
+	// 接受连接出现临时性错误时的退避时间
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = 1 * time.Second
+	// 读取 PROXY protocol 头部的超时时间
+	proxyHeaderTimeout = 5 * time.Second
+	// 同时等待读取 PROXY protocol 头部的最大连接数，此时尚未按真实来源地址计入连接数限制
+	proxyHeaderMaxPending = 1024
 )
 
 type Serve struct {
@@ -14,7 +33,26 @@ type Serve struct {
 	options *options.Options
 	// 处理消息回调接口
 	handler Handler
-	svr     server
+
+	locker      sync.Mutex
+	listeners   map[server]struct{}
+	connections map[int64]*Connection
+	inShutdown  int32
+
+	// 等待读取 PROXY protocol 头部的连接数，连接与监听器一同登记，服务关闭时关闭
+	proxyPending int
+
+	// 连接数限制计数
+	active int
+	perIP  map[string]int
+
+	// 分组
+	groupLocker sync.RWMutex
+	groups      map[string]map[int64]*Connection
+	memberOf    map[int64]map[string]struct{}
+
+	// 流量统计，已断开连接的累计值
+	closedStats ServeStats
 }
 type server interface {
 	Close() error
@@ -23,66 +61,579 @@ type server interface {
 func NewServe(address string, handler Handler, opts ...options.Option) *Serve {
 	utils.SetLimit()
 	return &Serve{
-		options: options.GetOptions(opts...),
-		address: address,
-		handler: handler,
+		options:     options.GetOptions(opts...),
+		address:     address,
+		handler:     handler,
+		listeners:   map[server]struct{}{},
+		connections: map[int64]*Connection{},
+		perIP:       map[string]int{},
+		groups:      map[string]map[int64]*Connection{},
+		memberOf:    map[int64]map[string]struct{}{},
 	}
 }
 
 func (s *Serve) RunUDP() error {
+	return s.RunUDPContext(context.Background())
+}
+
+// RunUDPContext 运行UDP服务，ctx 取消时返回 ctx.Err()，服务关闭时返回 net.ErrClosed
+func (s *Serve) RunUDPContext(ctx context.Context) error {
 	log.Info("[Serve] Run ", s.address, " udp server")
-	udpAddr, err := net.ResolveUDPAddr("udp", s.address)
-	if err != nil {
-		return err
-	}
-	conn, err := net.ListenUDP("udp", udpAddr)
+	conns, err := s.listenPacket("udp")
 	if err != nil {
 		return err
 	}
-	s.svr = conn
-	newConnection(conn, s.handler, s.options, true, false).setupUDP()
-	return nil
+	return serveAll(ctx, len(conns), func(ctx context.Context, i int) error {
+		return s.servePacket(ctx, conns[i])
+	})
 }
 
 func (s *Serve) RunTCP() error {
+	return s.RunTCPContext(context.Background())
+}
+
+// RunTCPContext 运行TCP服务，ctx 取消时返回 ctx.Err()，服务关闭时返回 net.ErrClosed
+func (s *Serve) RunTCPContext(ctx context.Context) error {
 	log.Info("[Serve] Run ", s.address, " tcp server")
-	ln, err := net.Listen("tcp", s.address)
+	lns, err := s.listen("tcp")
 	if err != nil {
 		return err
 	}
-	s.svr = ln
-	defer ln.Close()
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Error("new tcp client connection error ", err)
-			continue
-		}
-		go newConnection(conn, s.handler, s.options, false, false).setupTCP()
-	}
+	return serveAll(ctx, len(lns), func(ctx context.Context, i int) error {
+		return s.serve(ctx, lns[i], s.handleStream)
+	})
 }
 
 func (s *Serve) RunTLS(cfg *tls.Config) error {
+	return s.RunTLSContext(context.Background(), cfg)
+}
+
+// RunTLSContext 运行TLS服务，ctx 取消时返回 ctx.Err()，服务关闭时返回 net.ErrClosed
+func (s *Serve) RunTLSContext(ctx context.Context, cfg *tls.Config) error {
 
 	log.Info("[Serve] Run ", s.address, " tls server")
 
-	ln, err := net.Listen("tcp", s.address)
+	lns, err := s.listen("tcp")
+	if err != nil {
+		return err
+	}
+	return serveAll(ctx, len(lns), func(ctx context.Context, i int) error {
+		return s.serve(ctx, tls.NewListener(lns[i], cfg), s.handleStream)
+	})
+}
+
+func (s *Serve) RunUnix() error {
+	return s.RunUnixContext(context.Background())
+}
+
+// RunUnixContext 以 address 为路径运行 Unix 流式服务，ctx 取消时返回 ctx.Err()，服务关闭时返回 net.ErrClosed
+func (s *Serve) RunUnixContext(ctx context.Context) error {
+	log.Info("[Serve] Run ", s.address, " unix server")
+	ln, err := net.Listen("unix", s.address)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx, ln, s.handleStream)
+}
+
+func (s *Serve) RunUnixgram() error {
+	return s.RunUnixgramContext(context.Background())
+}
+
+// RunUnixgramContext 以 address 为路径运行 Unix 数据报服务，ctx 取消时返回 ctx.Err()，服务关闭时返回 net.ErrClosed
+func (s *Serve) RunUnixgramContext(ctx context.Context) error {
+	log.Info("[Serve] Run ", s.address, " unixgram server")
+	conn, err := net.ListenPacket("unixgram", s.address)
+	if err != nil {
+		return err
+	}
+	return s.servePacket(ctx, conn)
+}
+
+func (s *Serve) RunRUDP() error {
+	return s.RunRUDPContext(context.Background())
+}
+
+// RunRUDPContext 运行可靠UDP服务，连接按流式连接处理，ctx 取消时返回 ctx.Err()，服务关闭时返回 net.ErrClosed
+func (s *Serve) RunRUDPContext(ctx context.Context) error {
+	log.Info("[Serve] Run ", s.address, " rudp server")
+	conns, err := s.listenPacket("udp")
 	if err != nil {
 		return err
 	}
-	s.svr = ln
+	return serveAll(ctx, len(conns), func(ctx context.Context, i int) error {
+		return s.serve(ctx, rudp.NewListener(newRUDPPacketConn(conns[i], s.options), nil), s.handleStream)
+	})
+}
+
+// 打开监听，开启 ReusePort 时以 SO_REUSEPORT 打开多个监听
+func (s *Serve) listen(network string) ([]net.Listener, error) {
+	if s.options.ReusePort == 0 {
+		ln, err := net.Listen(network, s.address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+	n := s.reusePort()
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		ln, err := utils.ListenReuseAddr(network, s.address)
+		if err != nil {
+			for _, ln := range lns {
+				_ = ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// 打开数据报监听，开启 ReusePort 时以 SO_REUSEPORT 打开多个监听
+func (s *Serve) listenPacket(network string) ([]net.PacketConn, error) {
+	if s.options.ReusePort == 0 {
+		conn, err := net.ListenPacket(network, s.address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.PacketConn{conn}, nil
+	}
+	n := s.reusePort()
+	conns := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := utils.ListenReusePacket(network, s.address)
+		if err != nil {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// SO_REUSEPORT 监听数量，系统不支持时只打开一个监听
+func (s *Serve) reusePort() int {
+	if !utils.ReusePortSupported && s.options.ReusePort > 1 {
+		log.Warn("[Serve] SO_REUSEPORT is not supported, open only one listener")
+		return 1
+	}
+	return s.options.ReusePort
+}
+
+// 并发运行 n 个服务循环，任一返回后停止其余循环，返回第一个错误
+func serveAll(ctx context.Context, n int, run func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			errs <- run(ctx, i)
+		}(i)
+	}
+	var err error
+	for i := 0; i < n; i++ {
+		if e := <-errs; err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+// ServeListener 使用调用方提供的监听器运行服务，如 systemd socket activation、Unix socket 或包装过的监听器
+// 接受的 *tls.Conn 按TLS处理，其余按TCP流处理，不支持 netpoll 的连接将使用阻塞读取
+func (s *Serve) ServeListener(ln net.Listener) error {
+	log.Info("[Serve] Serve ", ln.Addr(), " listener")
+	return s.serve(context.Background(), ln, s.handleStream)
+}
+
+// ServePacketConn 使用调用方提供的数据报连接运行服务，如 *net.UDPConn、*net.UnixConn 或包装过的数据报连接
+func (s *Serve) ServePacketConn(pc net.PacketConn) error {
+	log.Info("[Serve] Serve ", pc.LocalAddr(), " packet conn")
+	return s.servePacket(context.Background(), pc)
+}
+
+// 处理新接入的流式连接
+func (s *Serve) handleStream(conn net.Conn) {
+	addr := conn.RemoteAddr()
+	var header *proxyproto.Header
+	if s.options.ProxyProtocol {
+		var err error
+		header, err = readProxyHeader(conn)
+		s.untrackProxyPending(conn)
+		if err != nil {
+			log.Warn("[Serve] read proxy protocol header from ", addr, " error ", err)
+			s.reject(conn, RejectProxyProtocol)
+			return
+		}
+		// 以真实来源地址进行访问控制及连接数限制，LOCAL、UNKNOWN 或来源不是IP地址时使用代理的地址
+		if header.SourceAddr != nil && addrIP(header.SourceAddr) != "" {
+			addr = header.SourceAddr
+		}
+		if !s.admit(addr) {
+			_ = conn.Close()
+			return
+		}
+		if reason := s.acquire(addr); reason != "" {
+			s.onReject(addr, reason)
+			_ = conn.Close()
+			return
+		}
+	}
+
+	// 通过访问控制及连接数限制后计入接受的连接数
+	metricAccepts.Inc()
+	applySocketOptions(conn, s.options)
+	c := initConnection(conn, s, s.handler, s.options, false, false)
+	c.limitAddr = addr
+	if header != nil {
+		c.proxyHeader = header
+		c.remoteAddr = addr.String()
+	}
+	c.open()
+	if _, ok := conn.(*tls.Conn); ok {
+		c.setupTLS()
+	} else {
+		c.setupTCP()
+	}
+}
+
+// 读取 PROXY protocol 头部，TLS 连接在握手之前从底层连接读取
+func readProxyHeader(conn net.Conn) (*proxyproto.Header, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	header, err := proxyproto.ReadHeader(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	return header, err
+}
+
+// 数据报读取循环，按对端地址维护会话
+func (s *Serve) servePacket(ctx context.Context, pc net.PacketConn) error {
+	if !s.trackListener(pc) {
+		return net.ErrClosed
+	}
+	defer s.untrackListener(pc)
+	defer pc.Close()
+	stop := closeOnDone(ctx, pc)
+	defer stop()
+
+	applyPacketOptions(pc, s.options)
+	sessions := newPacketSessions(s, pc)
+	sessions.readLoop()
+	// 服务关闭时会话已登记在服务中，由 Shutdown 以 "server shutdown" 或 Close 以 "server close" 断开
+	if !s.shuttingDown() {
+		sessions.closeAll("server close")
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return net.ErrClosed
+}
+
+// 接受连接循环
+// 临时性错误按指数退避重试，其他错误直接返回
+func (s *Serve) serve(ctx context.Context, ln net.Listener, handle func(conn net.Conn)) error {
+	if !s.trackListener(ln) {
+		return net.ErrClosed
+	}
+	defer s.untrackListener(ln)
 	defer ln.Close()
-	tlsListener := tls.NewListener(ln, cfg)
-	defer tlsListener.Close()
+	stop := closeOnDone(ctx, ln)
+	defer stop()
+
+	var delay time.Duration
 	for {
-		conn, err := tlsListener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
-			log.Error("new tls client connection error ", err)
-			continue
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if s.shuttingDown() || errors.Is(err, net.ErrClosed) {
+				return net.ErrClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = acceptMinDelay
+				} else {
+					delay *= 2
+				}
+				if delay > acceptMaxDelay {
+					delay = acceptMaxDelay
+				}
+				log.Error("accept error: ", err, "; retrying in ", delay)
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+				}
+				continue
+			}
+			return err
+		}
+		delay = 0
+		// 开启 PROXY protocol 时，在读取头部后以真实来源地址进行访问控制及连接数限制
+		if s.options.ProxyProtocol {
+			if !s.trackProxyPending(conn) {
+				s.reject(conn, RejectProxyPending)
+				continue
+			}
+		} else {
+			if !s.admit(conn.RemoteAddr()) {
+				_ = conn.Close()
+				continue
+			}
+			if reason := s.acquire(conn.RemoteAddr()); reason != "" {
+				s.reject(conn, reason)
+				continue
+			}
+		}
+		go handle(conn)
+	}
+}
+
+// ctx 取消时关闭监听器，返回的函数用于结束监听
+func closeOnDone(ctx context.Context, ln server) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = ln.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// Shutdown 优雅关闭服务
+// 停止接受新连接，等待各连接已排队的消息处理完毕后以 "server shutdown" 原因断开，
+// 所有连接断开或 ctx 到期后返回
+func (s *Serve) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+	err := s.closeListeners()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	signaled := map[int64]bool{}
+	for {
+		s.locker.Lock()
+		conns := make([]*Connection, 0, len(s.connections))
+		for id, c := range s.connections {
+			if !signaled[id] {
+				signaled[id] = true
+				conns = append(conns, c)
+			}
+		}
+		remain := len(s.connections)
+		s.locker.Unlock()
+
+		if remain == 0 {
+			return err
+		}
+		for _, c := range conns {
+			c.shutdown(ctx, "server shutdown")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
-		go newConnection(conn.(*tls.Conn), s.handler, s.options, false, false).setupTLS()
 	}
 }
+
+// Close 立即关闭服务及其所有连接
 func (s *Serve) Close() error {
-	return s.Close()
+	atomic.StoreInt32(&s.inShutdown, 1)
+	err := s.closeListeners()
+
+	for _, c := range s.snapshot() {
+		_ = c.Close("server close")
+	}
+	return err
+}
+
+// Connection 按ID查找连接，不存在或已断开时返回 nil
+func (s *Serve) Connection(id int64) *Connection {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.connections[id]
+}
+
+// Range 遍历当前所有连接，f 返回 false 时停止遍历
+// 遍历的是连接快照，f 中可以断开连接
+func (s *Serve) Range(f func(c *Connection) bool) {
+	for _, c := range s.snapshot() {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// Count 当前连接数
+func (s *Serve) Count() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return len(s.connections)
+}
+
+// 当前所有连接的快照
+func (s *Serve) snapshot() []*Connection {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	conns := make([]*Connection, 0, len(s.connections))
+	for _, c := range s.connections {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (s *Serve) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// 记录监听器，服务已关闭时直接关闭监听器并返回 false
+func (s *Serve) trackListener(ln server) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.shuttingDown() {
+		_ = ln.Close()
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *Serve) untrackListener(ln server) {
+	s.locker.Lock()
+	delete(s.listeners, ln)
+	s.locker.Unlock()
+}
+
+// 登记等待读取 PROXY protocol 头部的连接，超出上限或服务关闭时返回 false
+func (s *Serve) trackProxyPending(conn net.Conn) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.shuttingDown() || s.proxyPending >= proxyHeaderMaxPending {
+		return false
+	}
+	s.proxyPending++
+	s.listeners[conn] = struct{}{}
+	return true
+}
+
+func (s *Serve) untrackProxyPending(conn net.Conn) {
+	s.locker.Lock()
+	s.proxyPending--
+	delete(s.listeners, conn)
+	s.locker.Unlock()
+}
+
+func (s *Serve) closeListeners() error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	var err error
+	for ln := range s.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.listeners, ln)
+	}
+	return err
+}
+
+func (s *Serve) addConnection(c *Connection) {
+	s.locker.Lock()
+	s.connections[c.connId] = c
+	s.closedStats.TotalConnections++
+	s.locker.Unlock()
+}
+
+func (s *Serve) removeConnection(c *Connection) {
+	s.locker.Lock()
+	if _, ok := s.connections[c.connId]; ok {
+		delete(s.connections, c.connId)
+		if c.limitAddr != nil {
+			s.releaseLocked(c.limitAddr)
+		}
+		s.closedStats.add(c.Stats())
+	}
+	s.locker.Unlock()
+	s.leaveAll(c)
+}
+
+// 占用连接数，超出限制时返回拒绝原因
+func (s *Serve) acquire(addr net.Addr) string {
+	ip := addrIP(addr)
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.options.MaxConnections > 0 && s.active >= s.options.MaxConnections {
+		return RejectMaxConnections
+	}
+	if ip != "" && s.options.MaxConnectionsPerIP > 0 && s.perIP[ip] >= s.options.MaxConnectionsPerIP {
+		return RejectMaxConnectionsPerIP
+	}
+	s.active++
+	if ip != "" {
+		s.perIP[ip]++
+	}
+	return ""
+}
+
+// 释放连接数
+func (s *Serve) releaseLocked(addr net.Addr) {
+	s.active--
+	if ip := addrIP(addr); ip != "" {
+		if s.perIP[ip] <= 1 {
+			delete(s.perIP, ip)
+		} else {
+			s.perIP[ip]--
+		}
+	}
+}
+
+// 检查来源地址是否允许访问
+func (s *Serve) admit(addr net.Addr) bool {
+	if s.options.ACL != nil && !s.options.ACL.AllowedAddr(addr) {
+		s.onReject(addr, RejectACL)
+		return false
+	}
+	if s.options.SPA != nil && !s.options.SPA.AllowedAddr(addr) {
+		s.onReject(addr, RejectSPA)
+		return false
+	}
+	return true
+}
+
+// 拒绝连接
+func (s *Serve) reject(conn net.Conn, reason string) {
+	s.onReject(conn.RemoteAddr(), reason)
+	_ = conn.Close()
+}
+
+func (s *Serve) onReject(addr net.Addr, reason string) {
+	metricRejects.WithLabelValues(reason).Inc()
+	if h, ok := s.handler.(RejectHandler); ok {
+		h.OnReject(addr, reason)
+	}
+}
+
+// 取得地址中的IP，非IP地址（如 Unix socket）返回空
+func addrIP(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+	return ""
 }
@@ -0,0 +1,148 @@
+package libnet
+
+import (
+	"errors"
+	"github.com/1uLang/libnet/options"
+	"sync"
+)
+
+var (
+	ErrConnectionClosed = errors.New("the connection is close")
+	ErrNotServeConn     = errors.New("the connection does not belong to a serve")
+)
+
+// Join 将连接加入分组，连接断开时自动退出所有分组
+func (s *Serve) Join(group string, c *Connection) error {
+	s.groupLocker.Lock()
+	defer s.groupLocker.Unlock()
+	if c.IsClose() {
+		return ErrConnectionClosed
+	}
+	members, ok := s.groups[group]
+	if !ok {
+		members = map[int64]*Connection{}
+		s.groups[group] = members
+	}
+	members[c.connId] = c
+	joined, ok := s.memberOf[c.connId]
+	if !ok {
+		joined = map[string]struct{}{}
+		s.memberOf[c.connId] = joined
+	}
+	joined[group] = struct{}{}
+	return nil
+}
+
+// Leave 将连接移出分组
+func (s *Serve) Leave(group string, c *Connection) {
+	s.groupLocker.Lock()
+	defer s.groupLocker.Unlock()
+	s.leaveLocked(group, c.connId)
+}
+
+// Members 取得分组内的所有连接
+func (s *Serve) Members(group string) []*Connection {
+	s.groupLocker.RLock()
+	defer s.groupLocker.RUnlock()
+	members := s.groups[group]
+	conns := make([]*Connection, 0, len(members))
+	for _, c := range members {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Broadcast 向分组内所有连接发送消息
+// 相同加解密参数的连接只加密一次，单个连接发送失败不影响其他连接，返回发送失败的连接ID及错误
+// 开启发送队列的连接放入队列后立即返回，未开启的连接并发同步发送，Broadcast 在所有连接发送完毕后返回，
+// 最长为写超时；成员较多时建议开启发送队列（options.WithWriteQueue），避免每次广播为每个成员启动协程
+func (s *Serve) Broadcast(group string, bytes []byte) map[int64]error {
+	var errs map[int64]error
+	var errLocker sync.Mutex
+	addErr := func(c *Connection, err error) {
+		errLocker.Lock()
+		defer errLocker.Unlock()
+		if errs == nil {
+			errs = map[int64]error{}
+		}
+		errs[c.connId] = err
+	}
+
+	var wg sync.WaitGroup
+	encoded := map[*options.Options][]byte{}
+	for _, c := range s.Members(group) {
+		data := bytes
+		if c.options != nil && c.options.EncryptMethod != nil {
+			var ok bool
+			data, ok = encoded[c.options]
+			if !ok {
+				var err error
+				data, err = c.options.EncryptMethod.Encrypt(bytes)
+				if err != nil {
+					addErr(c, &WriteError{Err: err})
+					continue
+				}
+				encoded[c.options] = data
+			}
+		}
+		if c.IsClose() {
+			addErr(c, ErrConnectionClosed)
+			continue
+		}
+		// 队列满时阻塞写入方的连接同样可能被慢速的对端拖住
+		if c.queue != nil && c.queue.policy != options.OverflowBlock {
+			if _, err := c.write(data); err != nil {
+				addErr(c, err)
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(c *Connection, data []byte) {
+			defer wg.Done()
+			if _, err := c.write(data); err != nil {
+				addErr(c, err)
+			}
+		}(c, data)
+	}
+	wg.Wait()
+	return errs
+}
+
+// 连接断开时退出所有分组
+func (s *Serve) leaveAll(c *Connection) {
+	s.groupLocker.Lock()
+	defer s.groupLocker.Unlock()
+	for group := range s.memberOf[c.connId] {
+		s.leaveLocked(group, c.connId)
+	}
+}
+
+func (s *Serve) leaveLocked(group string, id int64) {
+	if members, ok := s.groups[group]; ok {
+		delete(members, id)
+		if len(members) == 0 {
+			delete(s.groups, group)
+		}
+	}
+	if joined, ok := s.memberOf[id]; ok {
+		delete(joined, group)
+		if len(joined) == 0 {
+			delete(s.memberOf, id)
+		}
+	}
+}
+
+// Join 加入所属服务的分组
+func (this *Connection) Join(group string) error {
+	if this.serve == nil {
+		return ErrNotServeConn
+	}
+	return this.serve.Join(group, this)
+}
+
+// Leave 退出所属服务的分组
+func (this *Connection) Leave(group string) {
+	if this.serve != nil {
+		this.serve.Leave(group, this)
+	}
+}
@@ -0,0 +1,298 @@
+package libnet
+
+import (
+	"errors"
+	"github.com/1uLang/libnet/utils"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 未设置空闲超时时数据报会话的空闲超时，超时后断开会话并回调 OnClose
+	packetSessionTimeout = 60 * time.Second
+	// 单次系统调用收发的最大数据报数
+	packetBatchSize = 16
+)
+
+// 数据报服务的会话表，每个对端地址对应一个虚拟连接
+type packetSessions struct {
+	serve    *Serve
+	conn     net.PacketConn
+	batch    *utils.PacketBatch
+	locker   sync.Mutex
+	sessions map[string]*Connection
+
+	// 处理一批数据报期间的写入暂存于 pending，处理完毕后一次发送
+	writeLocker sync.Mutex
+	batching    bool
+	pending     []utils.Message
+}
+
+func newPacketSessions(serve *Serve, conn net.PacketConn) *packetSessions {
+	return &packetSessions{
+		serve:    serve,
+		conn:     conn,
+		batch:    utils.NewPacketBatch(conn, packetBatchSize),
+		sessions: map[string]*Connection{},
+	}
+}
+
+// 读取循环，按来源地址分发至对应会话，socket 关闭时返回
+// Linux 下使用 recvmmsg 一次读取多个数据报，处理期间的回复由 sendmmsg 一次发送
+func (p *packetSessions) readLoop() {
+	msgs := make([]utils.Message, packetBatchSize)
+	if !p.batch.Native() {
+		msgs = msgs[:1]
+	}
+	for i := range msgs {
+		msgs[i].Buf = bytePool.Get()
+	}
+	defer func() {
+		for _, msg := range msgs {
+			bytePool.Put(msg.Buf)
+		}
+	}()
+	var delay time.Duration
+	for {
+		n, err := p.batch.ReadBatch(msgs)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// 与接受连接循环相同按指数退避重试，避免持续出错时空转
+			if delay == 0 {
+				delay = acceptMinDelay
+			} else {
+				delay *= 2
+			}
+			if delay > acceptMaxDelay {
+				delay = acceptMaxDelay
+			}
+			log.Error("[Serve] read from error ", err, "; retrying in ", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		p.beginBatch()
+		for _, msg := range msgs[:n] {
+			p.dispatch(msg.Addr, msg.Buf[:msg.N])
+		}
+		p.endBatch()
+	}
+}
+
+// 将数据报交由对端的会话处理
+func (p *packetSessions) dispatch(addr net.Addr, data []byte) {
+	// 未绑定地址的 unixgram 对端没有来源地址，无法回复，也无法区分会话
+	if addr == nil {
+		p.serve.onReject(nil, RejectNoAddress)
+		return
+	}
+	// 访问控制
+	if !p.serve.admit(addr) {
+		return
+	}
+	if h, ok := p.serve.handler.(PacketHandler); ok {
+		p.serve.receivePacket(h, addr, data)
+		return
+	}
+	c := p.session(addr)
+	if c == nil {
+		return
+	}
+	if len(data) > 0 {
+		c.receive(data)
+	} else {
+		// 空数据报只刷新活动时间
+		c.stats.read(0)
+	}
+}
+
+// 不建立会话，解密后直接交由 PacketHandler 处理
+func (s *Serve) receivePacket(h PacketHandler, addr net.Addr, data []byte) {
+	if s.options != nil && s.options.EncryptMethod != nil {
+		decode, err := s.options.EncryptMethod.Decrypt(data)
+		if err != nil {
+			log.Debug("[Serve] decrypt packet from ", addr, " error ", err)
+			return
+		}
+		data = decode
+	}
+	h.OnPacket(addr, data)
+}
+
+// 开始处理一批数据报，仅在使用 sendmmsg 时暂存写入
+func (p *packetSessions) beginBatch() {
+	if !p.batch.Native() {
+		return
+	}
+	p.writeLocker.Lock()
+	p.batching = true
+	p.writeLocker.Unlock()
+}
+
+// 一批数据报处理完毕，发送暂存的写入
+func (p *packetSessions) endBatch() {
+	if !p.batch.Native() {
+		return
+	}
+	p.writeLocker.Lock()
+	p.batching = false
+	failures := p.flushLocked()
+	p.writeLocker.Unlock()
+	p.writeFail(failures)
+}
+
+// 处理数据报期间暂存写入，返回是否已暂存，暂存满一批时立即发送
+func (p *packetSessions) hold(b []byte, addr net.Addr) bool {
+	p.writeLocker.Lock()
+	if !p.batching {
+		p.writeLocker.Unlock()
+		return false
+	}
+	// 调用方可能在返回后复用 b
+	p.pending = append(p.pending, utils.Message{Buf: append([]byte(nil), b...), Addr: addr})
+	var failures []packetWriteFailure
+	if len(p.pending) >= packetBatchSize {
+		failures = p.flushLocked()
+	}
+	p.writeLocker.Unlock()
+	p.writeFail(failures)
+	return true
+}
+
+// 暂存写入发送失败的对端地址及错误
+type packetWriteFailure struct {
+	addr net.Addr
+	err  error
+}
+
+// 发送暂存的写入，跳过发送失败的数据报继续发送其余数据报，返回发送失败的数据报
+func (p *packetSessions) flushLocked() (failures []packetWriteFailure) {
+	msgs := p.pending
+	for len(msgs) > 0 {
+		n, err := p.batch.WriteBatch(msgs)
+		if err == nil {
+			break
+		}
+		failures = append(failures, packetWriteFailure{addr: msgs[n].Addr, err: err})
+		msgs = msgs[n+1:]
+	}
+	for i := range p.pending {
+		p.pending[i] = utils.Message{}
+	}
+	p.pending = p.pending[:0]
+	return failures
+}
+
+// 暂存的写入发送失败时与同步发送失败相同，回调对端会话的 ErrorHandler 并断开会话
+// 回调中可能写入，须在释放 writeLocker 后调用
+func (p *packetSessions) writeFail(failures []packetWriteFailure) {
+	for _, failure := range failures {
+		p.locker.Lock()
+		c := p.sessions[failure.addr.String()]
+		p.locker.Unlock()
+		if c != nil {
+			_ = c.writeFail(failure.err)
+		}
+	}
+}
+
+// 取得对端的会话，首个数据报到达时创建会话并回调 OnConnect，超出连接数限制时返回 nil
+func (p *packetSessions) session(addr net.Addr) *Connection {
+	key := addr.String()
+	p.locker.Lock()
+	c, ok := p.sessions[key]
+	p.locker.Unlock()
+	if ok && !c.IsClose() {
+		return c
+	}
+
+	if reason := p.serve.acquire(addr); reason != "" {
+		p.serve.onReject(addr, reason)
+		return nil
+	}
+	metricAccepts.Inc()
+	c = initConnection(&packetPeerConn{sessions: p, key: key, addr: addr}, p.serve, p.serve.handler, p.serve.options, true, false)
+	c.limitAddr = addr
+	c.remoteAddr = key
+	// 先登记再回调 OnConnect，OnConnect 中断开时能够正确移出
+	p.locker.Lock()
+	p.sessions[key] = c
+	p.locker.Unlock()
+	return c.open()
+}
+
+// 移出会话，会话已被同一对端的新会话替换时不处理
+func (p *packetSessions) remove(key string, peer *packetPeerConn) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if c, ok := p.sessions[key]; ok && c.conn == peer {
+		delete(p.sessions, key)
+	}
+}
+
+// 断开所有会话
+func (p *packetSessions) closeAll(reason string) {
+	p.locker.Lock()
+	conns := make([]*Connection, 0, len(p.sessions))
+	for _, c := range p.sessions {
+		conns = append(conns, c)
+	}
+	p.locker.Unlock()
+	for _, c := range conns {
+		_ = c.Close(reason)
+	}
+}
+
+// 会话的底层连接，写入时发送至对端地址，关闭时只移出会话表，不关闭共享的 socket
+// 会话的数据由会话表的读取循环分发，不支持 Read
+type packetPeerConn struct {
+	sessions *packetSessions
+	key      string
+	addr     net.Addr
+}
+
+func (p *packetPeerConn) Read(b []byte) (int, error) {
+	return 0, errors.New("packet session does not support read")
+}
+
+func (p *packetPeerConn) Write(b []byte) (int, error) {
+	if p.sessions.hold(b, p.addr) {
+		return len(b), nil
+	}
+	if conn, ok := p.sessions.conn.(*net.UDPConn); ok {
+		if addr, ok := p.addr.(*net.UDPAddr); ok {
+			return conn.WriteToUDP(b, addr)
+		}
+	}
+	return p.sessions.conn.WriteTo(b, p.addr)
+}
+
+func (p *packetPeerConn) Close() error {
+	p.sessions.remove(p.key, p)
+	return nil
+}
+
+func (p *packetPeerConn) LocalAddr() net.Addr {
+	return p.sessions.conn.LocalAddr()
+}
+
+func (p *packetPeerConn) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+// socket 为所有对端共享，不设置超时
+func (p *packetPeerConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *packetPeerConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *packetPeerConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
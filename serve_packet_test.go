@@ -0,0 +1,272 @@
+package libnet
+
+import (
+	"context"
+	"errors"
+	"github.com/1uLang/libnet/options"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type sessionHandler struct {
+	testHandler
+	ids sync.Map
+}
+
+func (h *sessionHandler) OnConnect(c *Connection) {
+	c.Set("peer", c.RemoteAddr())
+	h.testHandler.OnConnect(c)
+}
+
+// 回复会话上下文中记录的对端地址
+func (h *sessionHandler) OnMessage(c *Connection, bytes []byte) {
+	h.ids.Store(c.RemoteAddr(), c.ID())
+	_, _ = c.Write([]byte(c.GetString("peer")))
+}
+
+func TestServe_PacketSessions(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &sessionHandler{}
+	svr := NewServe("", handler, options.WithIdleTimeout(200*time.Millisecond))
+	go func() {
+		_ = svr.ServePacketConn(pc)
+	}()
+	defer svr.Close()
+
+	peers := make([]net.Conn, 2)
+	for i := range peers {
+		peers[i], err = net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peers[i].Close()
+	}
+	buf := make([]byte, 64)
+	for round := 0; round < 2; round++ {
+		for _, peer := range peers {
+			if _, err := peer.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			_ = peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+			n, err := peer.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != peer.LocalAddr().String() {
+				t.Fatal("unexpected reply:", string(buf[:n]), peer.LocalAddr())
+			}
+		}
+	}
+	handler.locker.Lock()
+	connects := handler.connects
+	handler.locker.Unlock()
+	if connects != 2 || svr.Count() != 2 {
+		t.Fatal("unexpected sessions:", connects, svr.Count())
+	}
+	id0, _ := handler.ids.Load(peers[0].LocalAddr().String())
+	id1, _ := handler.ids.Load(peers[1].LocalAddr().String())
+	if id0 == nil || id0 == id1 {
+		t.Fatal("unexpected session ids:", id0, id1)
+	}
+
+	// 空闲超时后断开会话，再次发送时创建新会话
+	waitFor(t, func() bool {
+		return svr.Count() == 0
+	})
+	if reasons := handler.closeReasons(); len(reasons) != 2 || reasons[0] != CloseIdle || reasons[1] != CloseIdle {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+	_, _ = peers[0].Write([]byte("again"))
+	waitFor(t, func() bool {
+		return svr.Count() == 1
+	})
+	if stats := svr.Stats(); stats.TotalConnections != 3 || stats.MessagesRead != 5 {
+		t.Fatalf("unexpected serve stats: %+v", stats)
+	}
+}
+
+func TestServe_PacketSessionsLimit(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &rejectHandler{rejects: make(chan string, 1)}
+	svr := NewServe("", handler, options.WithMaxConnectionsPerIP(1))
+	go func() {
+		_ = svr.ServePacketConn(pc)
+	}()
+	defer svr.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("hello"))
+	}
+	select {
+	case reason := <-handler.rejects:
+		if reason != RejectMaxConnectionsPerIP {
+			t.Fatal("unexpected reject reason:", reason)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("second peer not rejected")
+	}
+	if svr.Count() != 1 {
+		t.Fatal("unexpected sessions:", svr.Count())
+	}
+}
+
+func TestServe_PacketBatch(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{})
+	go func() {
+		_ = svr.ServePacketConn(pc)
+	}()
+	defer svr.Close()
+
+	// 连续发送多个数据报，一次读取的多个数据报的回复由 sendmmsg 一次发送
+	peers := make([]net.Conn, 3)
+	for i := range peers {
+		peers[i], err = net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peers[i].Close()
+	}
+	const count = 40
+	for j := 0; j < count; j++ {
+		for i, peer := range peers {
+			if _, err := peer.Write([]byte{byte(i), byte(j)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	buf := make([]byte, 64)
+	for i, peer := range peers {
+		for j := 0; j < count; j++ {
+			_ = peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+			n, err := peer.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 || buf[0] != byte(i) || buf[1] != byte(j) {
+				t.Fatal("unexpected reply:", buf[:n])
+			}
+		}
+	}
+}
+
+func TestServe_PacketSessionsShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &testHandler{}
+	svr := NewServe(addr, handler)
+	done := make(chan error, 1)
+	go func() {
+		done <- svr.RunUDP()
+	}()
+
+	peer, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	waitFor(t, func() bool {
+		_, _ = peer.Write([]byte("hello"))
+		return svr.Count() == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Fatal("unexpected run error:", err)
+	}
+	if reasons := handler.closeReasons(); len(reasons) != 1 || reasons[0] != "server shutdown" {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+}
+
+// 持续出错的数据报连接
+type failingPacketConn struct {
+	net.PacketConn
+	reads  atomic.Int32
+	closed atomic.Bool
+}
+
+func (c *failingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.closed.Load() {
+		return 0, nil, net.ErrClosed
+	}
+	c.reads.Add(1)
+	return 0, nil, errors.New("read failed")
+}
+
+func (c *failingPacketConn) Close() error {
+	c.closed.Store(true)
+	return c.PacketConn.Close()
+}
+
+// 读取持续出错时按指数退避重试
+func TestServe_PacketReadBackoff(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &failingPacketConn{PacketConn: pc}
+	svr := NewServe("", &testHandler{})
+	go func() {
+		_ = svr.ServePacketConn(conn)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	_ = svr.Close()
+	// 5ms 起每次翻倍，200ms 内约重试6次
+	if reads := conn.reads.Load(); reads > 10 {
+		t.Fatal("read retried without backoff:", reads)
+	}
+}
+
+// 暂存的写入发送失败时回调会话的 ErrorHandler 并断开会话
+func TestPacketSessions_FlushError(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	handler := &errorHandler{errs: make(chan error, 1)}
+	p := newPacketSessions(NewServe("", handler), pc)
+	if !p.batch.Native() {
+		t.Skip("sendmmsg not supported")
+	}
+	// 超过 UDP 最大长度的数据报发送失败
+	c := p.session(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	p.beginBatch()
+	if _, err := c.Write(make([]byte, 70000)); err != nil {
+		t.Fatal(err)
+	}
+	p.endBatch()
+	select {
+	case err := <-handler.errs:
+		var writeErr *WriteError
+		if !errors.As(err, &writeErr) {
+			t.Fatal("unexpected error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flush error not reported")
+	}
+	if !c.IsClose() {
+		t.Fatal("session not closed after flush error")
+	}
+}
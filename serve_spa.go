@@ -0,0 +1,39 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/spa"
+	log "github.com/sirupsen/logrus"
+	"net"
+)
+
+// 敲门服务的处理器，每个数据报交由授权门验证，无论成功与否都不回复，避免暴露服务
+// 实现 PacketHandler，敲门数据报在读取循环中直接验证，不建立会话
+type spaHandler struct {
+	gate *spa.Gate
+}
+
+// NewSPAHandler 创建敲门服务的处理器，以 RunUDP 运行，验签通过的来源IP由 gate 放行
+// 受保护的 TCP/TLS 服务以 options.WithSPA(gate) 运行；敲门服务设置加密方法时，客户端需以相同方法加密敲门数据报
+func NewSPAHandler(gate *spa.Gate) Handler {
+	if gate == nil {
+		panic("spa gate not be nil")
+	}
+	return &spaHandler{gate: gate}
+}
+
+func (h *spaHandler) OnPacket(addr net.Addr, data []byte) {
+	if err := h.gate.Knock(data, addr); err != nil {
+		log.Debug("[Serve] spa knock from ", addr, " error ", err)
+	} else {
+		log.Debug("[Serve] spa knock from ", addr, " accepted")
+	}
+}
+
+func (h *spaHandler) OnConnect(c *Connection) {}
+
+// 以流式服务运行时不处理，敲门只接受数据报
+func (h *spaHandler) OnMessage(c *Connection, bytes []byte) {
+	_ = c.Close(CloseSPAStream)
+}
+
+func (h *spaHandler) OnClose(c *Connection, msg string) {}
@@ -0,0 +1,138 @@
+package libnet
+
+import (
+	"github.com/1uLang/libnet/encrypt"
+	"github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/spa"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServe_SPA(t *testing.T) {
+	signer, err := encrypt.NewHMACSM3Signer([]byte("knock key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := spa.New(signer, time.Minute)
+
+	// 加密的敲门服务
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := encrypt.NewMethodInstance("aes-128-cfb", "key", "iv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	knockSvr := NewServe("", NewSPAHandler(gate), options.WithEncryptMethod(m))
+	go func() {
+		_ = knockSvr.ServePacketConn(pc)
+	}()
+	defer knockSvr.Close()
+
+	// 受保护的 TCP 服务
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &rejectHandler{rejects: make(chan string, 1)}
+	svr := NewServe("", handler, options.WithSPA(gate))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	knock := func(signer encrypt.Signer) {
+		packet, err := spa.NewPacketWithIP(signer, net.ParseIP("127.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := encrypt.NewMethodInstance("aes-128-cfb", "key", "iv")
+		client, err := NewClient(pc.LocalAddr().String(), &testHandler{}, options.WithEncryptMethod(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DialUDP(); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if _, err := client.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dialRejected := func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		select {
+		case reason := <-handler.rejects:
+			if reason != RejectSPA {
+				t.Fatal("unexpected reject reason:", reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("peer without knock not rejected")
+		}
+	}
+
+	// 未敲门及签名错误的敲门均被拒绝
+	dialRejected()
+	forged, _ := encrypt.NewHMACSM3Signer([]byte("forged key"))
+	knock(forged)
+	time.Sleep(50 * time.Millisecond)
+	dialRejected()
+
+	knock(signer)
+	waitFor(t, func() bool {
+		return gate.Allowed(net.ParseIP("127.0.0.1"))
+	})
+	// 敲门数据报不建立会话
+	if stats := knockSvr.Stats(); stats.TotalConnections != 0 {
+		t.Fatal("unexpected knock sessions:", stats.TotalConnections)
+	}
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return handler.connects == 1
+	})
+}
+
+// 敲门服务以流式服务运行时收到数据即断开
+func TestServe_SPAStream(t *testing.T) {
+	signer, _ := encrypt.NewHMACSHA256Signer([]byte("knock key"))
+	gate := spa.New(signer, time.Minute)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", NewSPAHandler(gate))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	packet, _ := spa.NewPacket(signer)
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("stream knock not closed:", err)
+	}
+	if gate.Allowed(net.ParseIP("127.0.0.1")) {
+		t.Fatal("stream knock should not be accepted")
+	}
+}
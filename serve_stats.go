@@ -0,0 +1,32 @@
+package libnet
+
+// ServeStats 服务流量统计，流量计数包含已断开的连接
+type ServeStats struct {
+	Connections      int    // 当前连接数
+	TotalConnections uint64 // 累计连接数
+	BytesRead        uint64 // 读取的字节数
+	BytesWritten     uint64 // 发送的字节数
+	MessagesRead     uint64 // 读取的消息数
+	MessagesWritten  uint64 // 发送的消息数
+	DecryptErrors    uint64 // 解密失败次数
+}
+
+func (s *ServeStats) add(stats Stats) {
+	s.BytesRead += stats.BytesRead
+	s.BytesWritten += stats.BytesWritten
+	s.MessagesRead += stats.MessagesRead
+	s.MessagesWritten += stats.MessagesWritten
+	s.DecryptErrors += stats.DecryptErrors
+}
+
+// Stats 取得服务流量统计，单个连接的统计可通过 Range 及 Connection.Stats 取得
+func (s *Serve) Stats() ServeStats {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	stats := s.closedStats
+	stats.Connections = len(s.connections)
+	for _, c := range s.connections {
+		stats.add(c.Stats())
+	}
+	return stats
+}
@@ -0,0 +1,836 @@
+package libnet
+
+import (
+	"context"
+	"errors"
+	"github.com/1uLang/libnet/acl"
+	"github.com/1uLang/libnet/options"
+	"github.com/1uLang/libnet/proxyproto"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testHandler struct {
+	locker   sync.Mutex
+	connects int
+	messages [][]byte
+	closes   []string
+}
+
+func (h *testHandler) OnConnect(c *Connection) {
+	h.locker.Lock()
+	h.connects++
+	h.locker.Unlock()
+}
+
+func (h *testHandler) OnMessage(c *Connection, bytes []byte) {
+	h.locker.Lock()
+	h.messages = append(h.messages, append([]byte{}, bytes...))
+	h.locker.Unlock()
+	_, _ = c.Write(bytes)
+}
+
+func (h *testHandler) OnClose(c *Connection, msg string) {
+	h.locker.Lock()
+	h.closes = append(h.closes, msg)
+	h.locker.Unlock()
+}
+
+func (h *testHandler) closeReasons() []string {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	return append([]string{}, h.closes...)
+}
+
+// 获取一个空闲的本地地址
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+// 等待条件满足
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	handler := &testHandler{}
+	addr := freeAddr(t)
+	svr := NewServe(addr, handler)
+	done := make(chan error, 1)
+	go func() {
+		done <- svr.RunTCP()
+	}()
+
+	var conn net.Conn
+	waitFor(t, func() bool {
+		var err error
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	})
+	defer conn.Close()
+
+	_, err := conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatal("unexpected echo:", string(buf[:n]))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatal("unexpected run error:", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("accept loop not stopped")
+	}
+	reasons := handler.closeReasons()
+	if len(reasons) != 1 || reasons[0] != "server shutdown" {
+		t.Fatal("unexpected close reasons:", reasons)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("listener still accepting")
+	}
+}
+
+func TestServe_RunTCPContext(t *testing.T) {
+	svr := NewServe(freeAddr(t), &testHandler{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- svr.RunTCPContext(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal("unexpected run error:", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("accept loop not stopped")
+	}
+}
+
+// 包装后的监听器，接受的连接不支持 netpoll
+type wrappedListener struct {
+	net.Listener
+}
+
+type wrappedConn struct {
+	net.Conn
+}
+
+func (ln wrappedListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return wrappedConn{conn}, nil
+}
+
+func TestServe_ServeListener(t *testing.T) {
+	for _, wrap := range []bool{false, true} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var served net.Listener = ln
+		if wrap {
+			served = wrappedListener{ln}
+		}
+		handler := &testHandler{}
+		svr := NewServe("", handler)
+		go func() {
+			_ = svr.ServeListener(served)
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "ping" {
+			t.Fatal("unexpected echo:", string(buf[:n]))
+		}
+		_ = conn.Close()
+		_ = svr.Close()
+	}
+}
+
+func TestServe_ServePacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &testHandler{}
+	svr := NewServe("", handler)
+	done := make(chan error, 1)
+	go func() {
+		done <- svr.ServePacketConn(pc)
+	}()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("knock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return len(handler.messages) == 1 && string(handler.messages[0]) == "knock"
+	})
+	_ = svr.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatal("unexpected serve error:", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("packet loop not stopped")
+	}
+}
+
+// 只实现 net.PacketConn 的包装连接
+type wrappedPacketConn struct {
+	net.PacketConn
+}
+
+func TestServe_ServeWrappedPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{})
+	go func() {
+		_ = svr.ServePacketConn(wrappedPacketConn{pc})
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("echo"))
+	buf := make([]byte, 8)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "echo" {
+		t.Fatal("unexpected echo:", string(buf[:n]), err)
+	}
+}
+
+func TestServe_ReusePort(t *testing.T) {
+	addr := freeAddr(t)
+	svr := NewServe(addr, &testHandler{}, options.WithReusePort(4))
+	done := make(chan error, 1)
+	go func() {
+		done <- svr.RunTCP()
+	}()
+	for i := 0; i < 8; i++ {
+		var conn net.Conn
+		waitFor(t, func() bool {
+			var err error
+			conn, err = net.Dial("tcp", addr)
+			return err == nil
+		})
+		_, _ = conn.Write([]byte("hi"))
+		buf := make([]byte, 4)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "hi" {
+			t.Fatal("unexpected echo:", string(buf[:n]), err)
+		}
+		_ = conn.Close()
+	}
+	_ = svr.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Fatal("unexpected run error:", err)
+	}
+}
+
+func TestServe_RunUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "libnet.sock")
+	svr := NewServe(path, &testHandler{})
+	go func() {
+		_ = svr.RunUnix()
+	}()
+	defer svr.Close()
+
+	handler := &testHandler{}
+	client, err := NewClient(path, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return client.DialUnix() == nil
+	})
+	defer client.Close()
+	_, err = client.Write([]byte("unix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return len(handler.messages) > 0 && string(handler.messages[0]) == "unix"
+	})
+}
+
+func TestServe_RunUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "libnet.sock")
+	handler := &testHandler{}
+	svr := NewServe(path, handler)
+	go func() {
+		_ = svr.RunUnixgram()
+	}()
+	defer svr.Close()
+
+	client, err := NewClient(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return client.DialUnixgram() == nil
+	})
+	defer client.Close()
+	_, err = client.Write([]byte("unixgram"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return len(handler.messages) > 0 && string(handler.messages[0]) == "unixgram"
+	})
+}
+
+func TestServe_UnixgramSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "libnet.sock")
+	handler := &sessionHandler{}
+	svr := NewServe(path, handler)
+	go func() {
+		_ = svr.RunUnixgram()
+	}()
+	defer svr.Close()
+
+	handlers := []*testHandler{{}, {}}
+	for _, h := range handlers {
+		client, err := NewClient(path, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return client.DialUnixgram() == nil
+		})
+		defer client.Close()
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 每个客户端收到回复，回复内容为服务端看到的各自的地址
+	waitFor(t, func() bool {
+		for _, h := range handlers {
+			h.locker.Lock()
+			n := len(h.messages)
+			h.locker.Unlock()
+			if n == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	if string(handlers[0].messages[0]) == string(handlers[1].messages[0]) || svr.Count() != 2 {
+		t.Fatal("unexpected sessions:", string(handlers[0].messages[0]), string(handlers[1].messages[0]), svr.Count())
+	}
+
+	// 未绑定地址的数据报被拒绝
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if svr.Count() != 2 {
+		t.Fatal("unaddressed datagram opened a session")
+	}
+}
+
+type rejectHandler struct {
+	testHandler
+	rejects chan string
+}
+
+func (h *rejectHandler) OnReject(addr net.Addr, reason string) {
+	h.rejects <- reason
+}
+
+func TestServe_MaxConnections(t *testing.T) {
+	for _, opt := range []options.Option{options.WithMaxConnections(1), options.WithMaxConnectionsPerIP(1)} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := &rejectHandler{rejects: make(chan string, 1)}
+		svr := NewServe("", handler, opt)
+		go func() {
+			_ = svr.ServeListener(ln)
+		}()
+
+		first, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return svr.Count() == 1
+		})
+		second, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case reason := <-handler.rejects:
+			if reason != RejectMaxConnections && reason != RejectMaxConnectionsPerIP {
+				t.Fatal("unexpected reject reason:", reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("excess connection not rejected")
+		}
+		_ = second.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err := second.Read(make([]byte, 1)); err == nil {
+			t.Fatal("rejected connection still open")
+		}
+
+		// 释放后可再次连接
+		_ = first.Close()
+		waitFor(t, func() bool {
+			return svr.Count() == 0
+		})
+		third, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			return svr.Count() == 1
+		})
+		_ = third.Close()
+		_ = second.Close()
+		_ = svr.Close()
+	}
+}
+
+func TestServe_ACL(t *testing.T) {
+	rules, err := acl.New(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &rejectHandler{rejects: make(chan string, 2)}
+	svr := NewServe("", handler, options.WithACL(rules))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	go func() {
+		_ = svr.ServePacketConn(pc)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	udpConn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	_, _ = udpConn.Write([]byte("denied"))
+	for i := 0; i < 2; i++ {
+		select {
+		case reason := <-handler.rejects:
+			if reason != RejectACL {
+				t.Fatal("unexpected reject reason:", reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("denied peer not rejected")
+		}
+	}
+
+	// 热更新规则后允许访问
+	if err := rules.Reload([]string{"127.0.0.1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = udpConn.Write([]byte("allowed"))
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return len(handler.messages) == 1 && string(handler.messages[0]) == "allowed"
+	})
+}
+
+type proxyHandler struct {
+	testHandler
+	remote chan string
+}
+
+func (h *proxyHandler) OnConnect(c *Connection) {
+	h.remote <- c.RemoteAddr()
+}
+
+func TestServe_ProxyProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &proxyHandler{remote: make(chan string, 1)}
+	svr := NewServe("", handler, options.WithProxyProtocol(true))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\nhello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case remote := <-handler.remote:
+		if remote != "203.0.113.7:40000" {
+			t.Fatal("unexpected remote addr:", remote)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection not accepted")
+	}
+	buf := make([]byte, 16)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatal("unexpected echo:", string(buf[:n]), err)
+	}
+
+	// 头部错误的连接被拒绝
+	bad, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	_, _ = bad.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	_ = bad.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := bad.Read(buf); err == nil {
+		t.Fatal("malformed header not rejected")
+	}
+}
+
+func TestServe_ProxyProtocolACL(t *testing.T) {
+	rules, err := acl.New(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &rejectHandler{rejects: make(chan string, 1)}
+	svr := NewServe("", handler, options.WithProxyProtocol(true), options.WithACL(rules))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	local, _ := (&proxyproto.Header{Version: 2, Command: proxyproto.CommandLocal}).Marshal()
+	// v2 PROXY AF_UNIX 流式连接，地址块为 108 字节的来源及目标路径
+	unix := append(append([]byte{}, local[:12]...), 0x21, 0x31, 0, 216)
+	unix = append(unix, make([]byte, 216)...)
+	copy(unix[16:], "/tmp/src.sock")
+	copy(unix[16+108:], "/tmp/dst.sock")
+
+	// 头部不携带IP来源地址时，以代理的地址进行访问控制
+	for _, header := range [][]byte{[]byte("PROXY UNKNOWN\r\n"), local, unix} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = conn.Write(header)
+		select {
+		case reason := <-handler.rejects:
+			if reason != RejectACL {
+				t.Fatal("unexpected reject reason:", reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("header without source not rejected:", string(header))
+		}
+		_ = conn.Close()
+	}
+
+	// 以头部中的真实来源地址进行访问控制
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\n"))
+	waitFor(t, func() bool {
+		handler.locker.Lock()
+		defer handler.locker.Unlock()
+		return handler.connects == 1
+	})
+	if len(handler.rejects) != 0 {
+		t.Fatal("proxied source rejected")
+	}
+}
+
+// 等待读取头部的连接登记在服务中，服务关闭时立即关闭
+func TestServe_ProxyProtocolPending(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{}, options.WithProxyProtocol(true))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pending := func() int {
+		svr.locker.Lock()
+		defer svr.locker.Unlock()
+		return svr.proxyPending
+	}
+	waitFor(t, func() bool {
+		return pending() == 1
+	})
+	_ = svr.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("pending connection not closed on shutdown:", err)
+	}
+	waitFor(t, func() bool {
+		return pending() == 0
+	})
+}
+
+func TestServe_Registry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{})
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	waitFor(t, func() bool {
+		return svr.Count() == 3
+	})
+
+	var ids []int64
+	svr.Range(func(c *Connection) bool {
+		ids = append(ids, c.ID())
+		return true
+	})
+	if len(ids) != 3 {
+		t.Fatal("unexpected range result:", ids)
+	}
+	c := svr.Connection(ids[0])
+	if c == nil || c.ID() != ids[0] {
+		t.Fatal("connection not found")
+	}
+	_ = c.Close("kick")
+	if svr.Connection(ids[0]) != nil || svr.Count() != 2 {
+		t.Fatal("closed connection still registered")
+	}
+}
+
+func TestServe_Broadcast(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{})
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+	waitFor(t, func() bool {
+		return svr.Count() == 3
+	})
+	var conns []*Connection
+	svr.Range(func(c *Connection) bool {
+		conns = append(conns, c)
+		return true
+	})
+	for _, c := range conns[:2] {
+		if err := c.Join("room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(svr.Members("room")) != 2 {
+		t.Fatal("unexpected members")
+	}
+	if errs := svr.Broadcast("room", []byte("hi")); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	received := 0
+	for _, conn := range clients {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 8)
+		n, _ := conn.Read(buf)
+		if n > 0 {
+			if string(buf[:n]) != "hi" {
+				t.Fatal("unexpected message:", string(buf[:n]))
+			}
+			received++
+		}
+	}
+	if received != 2 {
+		t.Fatal("unexpected receivers:", received)
+	}
+
+	// 断开的连接自动退出分组
+	_ = conns[0].Close("kick")
+	if len(svr.Members("room")) != 1 {
+		t.Fatal("closed connection still in group")
+	}
+	if err := conns[0].Join("room"); err == nil {
+		t.Fatal("closed connection joined group")
+	}
+	conns[1].Leave("room")
+	if len(svr.Members("room")) != 0 {
+		t.Fatal("connection still in group after leave")
+	}
+}
+
+// 未开启发送队列时，慢速的成员不阻塞其他成员
+func TestServe_BroadcastSlowMember(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := NewServe("", &testHandler{}, options.WithWriteTimeout(3*time.Second), options.WithWriteBuffer(64*1024))
+	go func() {
+		_ = svr.ServeListener(ln)
+	}()
+	defer svr.Close()
+
+	var clients []net.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+	waitFor(t, func() bool {
+		return svr.Count() == 4
+	})
+	svr.Range(func(c *Connection) bool {
+		_ = c.Join("room")
+		return true
+	})
+
+	// 只有一个成员读取，其余成员不读取直至写超时
+	payload := make([]byte, 16*1024*1024)
+	received := make(chan time.Duration, 1)
+	start := time.Now()
+	go func() {
+		_, _ = io.ReadFull(clients[0], make([]byte, len(payload)))
+		received <- time.Since(start)
+	}()
+	errs := svr.Broadcast("room", payload)
+	if len(errs) != 3 {
+		t.Fatal("unexpected errors:", errs)
+	}
+	// 并发发送，最长为一次写超时
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatal("slow members written one after another:", elapsed)
+	}
+	select {
+	case elapsed := <-received:
+		if elapsed > 2*time.Second {
+			t.Fatal("fast member delayed by slow members:", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast member not received")
+	}
+}
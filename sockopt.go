@@ -0,0 +1,70 @@
+package libnet
+
+import (
+	"crypto/tls"
+	"github.com/1uLang/libnet/options"
+	log "github.com/sirupsen/logrus"
+	"net"
+)
+
+// 可设置收发缓冲区的连接，如 TCP、UDP、Unix socket
+type bufferConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// 按参数设置 socket，用于接受及主动建立的 TCP、TLS、UDP 连接
+func applySocketOptions(conn net.Conn, opts *options.Options) {
+	if opts == nil {
+		return
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if opts.TCPKeepAlive > 0 {
+			logSocketError(tcpConn.SetKeepAlive(true))
+			logSocketError(tcpConn.SetKeepAlivePeriod(opts.TCPKeepAlive))
+		} else if opts.TCPKeepAlive < 0 {
+			logSocketError(tcpConn.SetKeepAlive(false))
+		}
+		if opts.NoDelay != nil {
+			logSocketError(tcpConn.SetNoDelay(*opts.NoDelay))
+		}
+		if opts.Linger != nil {
+			logSocketError(tcpConn.SetLinger(*opts.Linger))
+		}
+	}
+	applyBufferOptions(conn, opts)
+}
+
+// 按参数设置数据报 socket 的收发缓冲区，包装过的数据报连接不支持时忽略
+func applyPacketOptions(pc net.PacketConn, opts *options.Options) {
+	if opts == nil {
+		return
+	}
+	applyBufferOptions(pc, opts)
+}
+
+// 取得 rudp 使用的数据报 socket，rudp.Conn 不是 socket，收发缓冲区在包装之前设置
+func newRUDPPacketConn(pc net.PacketConn, opts *options.Options) net.PacketConn {
+	applyPacketOptions(pc, opts)
+	return pc
+}
+
+func applyBufferOptions(conn interface{}, opts *options.Options) {
+	if bc, ok := conn.(bufferConn); ok {
+		if opts.ReadBuffer > 0 {
+			logSocketError(bc.SetReadBuffer(opts.ReadBuffer))
+		}
+		if opts.WriteBuffer > 0 {
+			logSocketError(bc.SetWriteBuffer(opts.WriteBuffer))
+		}
+	}
+}
+
+func logSocketError(err error) {
+	if err != nil {
+		log.Warn("[Connection] set socket option error ", err)
+	}
+}
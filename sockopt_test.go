@@ -0,0 +1,79 @@
+//go:build linux
+
+package libnet
+
+import (
+	"github.com/1uLang/libnet/options"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestApplySocketOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	applySocketOptions(conn, options.GetOptions(
+		options.WithTCPKeepAlive(30*time.Second),
+		options.WithNoDelay(false),
+		options.WithLinger(0),
+		options.WithReadBuffer(64*1024),
+	))
+
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rawConn.Control(func(fd uintptr) {
+		noDelay, _ := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		if noDelay != 0 {
+			t.Error("TCP_NODELAY not cleared")
+		}
+		keepAlive, _ := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		if keepAlive != 1 {
+			t.Error("SO_KEEPALIVE not set")
+		}
+		idle, _ := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+		if idle != 30 {
+			t.Error("unexpected TCP_KEEPIDLE", idle)
+		}
+		rcvBuf, _ := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		// 内核会将设置值翻倍
+		if rcvBuf < 64*1024 {
+			t.Error("unexpected SO_RCVBUF", rcvBuf)
+		}
+	})
+}
+
+func TestApplyPacketOptions(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	opts := options.GetOptions(options.WithReadBuffer(64*1024), options.WithWriteBuffer(64*1024))
+	applyPacketOptions(pc, opts)
+	// 包装过的数据报连接不支持时忽略
+	applyPacketOptions(wrappedPacketConn{pc}, opts)
+
+	rawConn, err := pc.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rawConn.Control(func(fd uintptr) {
+		rcvBuf, _ := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		sndBuf, _ := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		if rcvBuf < 64*1024 || sndBuf < 64*1024 {
+			t.Error("unexpected buffers", rcvBuf, sndBuf)
+		}
+	})
+}
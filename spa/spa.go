@@ -0,0 +1,236 @@
+// Package spa 单包授权（Single Packet Authorization）
+// 客户端向敲门端口发送一个带时间戳及随机数的签名数据报，服务端验签通过后在一段时间内放行该来源IP，
+// 未敲门的地址在握手之前即被拒绝，受保护的 TCP/TLS 端口对未授权的扫描不可见
+package spa
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/1uLang/libnet/encrypt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// 数据报格式
+// [1字节版本][8字节时间戳（Unix 毫秒）][16字节随机数][1字节IP长度][请求放行的IP][签名]
+// 签名覆盖签名之前的全部字节；IP长度为0时请求放行数据报的来源地址
+const (
+	version    = 1
+	nonceSize  = 16
+	headerSize = 1 + 8 + nonceSize + 1
+)
+
+// 允许的客户端与服务端时钟偏差，超出的数据报视为过期
+const maxClockSkew = 30 * time.Second
+
+var (
+	ErrInvalidPacket = errors.New("spa: invalid packet")
+	ErrBadSignature  = errors.New("spa: bad signature")
+	ErrExpired       = errors.New("spa: packet expired")
+	ErrReplayed      = errors.New("spa: packet replayed")
+	ErrAddrMismatch  = errors.New("spa: source address mismatch")
+)
+
+// NewPacket 构建敲门数据报，不携带请求放行的IP，放行数据报的来源地址
+// 适用于位于 NAT 之后、不知道出口地址的客户端，此时截获数据报的中间人可以抢先从自己的地址重放，
+// 服务端可以通过 Gate.SetRequireSourceIP 拒绝此类数据报
+func NewPacket(signer encrypt.Signer) ([]byte, error) {
+	return newPacket(signer, nil, time.Now())
+}
+
+// NewPacketWithIP 构建携带请求放行IP的敲门数据报，IP 经过签名不可篡改，数据报只能由该地址发出
+func NewPacketWithIP(signer encrypt.Signer, ip net.IP) ([]byte, error) {
+	return newPacket(signer, ip, time.Now())
+}
+
+func newPacket(signer encrypt.Signer, ip net.IP, now time.Time) ([]byte, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	buf := make([]byte, headerSize, headerSize+len(ip))
+	buf[0] = version
+	binary.BigEndian.PutUint64(buf[1:], uint64(now.UnixMilli()))
+	if _, err := rand.Read(buf[9 : 9+nonceSize]); err != nil {
+		return nil, err
+	}
+	buf[headerSize-1] = byte(len(ip))
+	buf = append(buf, ip...)
+	sign, err := signer.Sign(buf)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, sign...), nil
+}
+
+// Knock 向敲门端口发送一个不携带IP的敲门数据报，数据报不会得到响应
+func Knock(network, address string, signer encrypt.Signer) error {
+	return KnockWithIP(network, address, signer, nil)
+}
+
+// KnockWithIP 向敲门端口发送一个携带请求放行IP的敲门数据报，ip 为 nil 时同 Knock
+func KnockWithIP(network, address string, signer encrypt.Signer, ip net.IP) error {
+	packet, err := NewPacketWithIP(signer, ip)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
+
+// Gate 验证敲门数据报并记录放行的来源IP，并发安全
+type Gate struct {
+	signer encrypt.Signer
+	window time.Duration
+
+	locker          sync.Mutex
+	requireSourceIP bool
+	allowed         map[netip.Addr]time.Time // 放行截止时间
+	nonces          map[[nonceSize]byte]time.Time
+	nextSweep       time.Time
+}
+
+// New 新建授权门，验签通过的来源IP在 window 内可以建立新连接，已建立的连接不受放行过期影响
+func New(signer encrypt.Signer, window time.Duration) *Gate {
+	if signer == nil {
+		panic("spa signer not be nil")
+	}
+	if window <= 0 {
+		panic("spa window must greater than 0")
+	}
+	return &Gate{
+		signer:  signer,
+		window:  window,
+		allowed: map[netip.Addr]time.Time{},
+		nonces:  map[[nonceSize]byte]time.Time{},
+	}
+}
+
+// SetRequireSourceIP 设置是否要求数据报携带请求放行的IP，开启后拒绝不携带IP的数据报
+func (this *Gate) SetRequireSourceIP(require bool) {
+	this.locker.Lock()
+	this.requireSourceIP = require
+	this.locker.Unlock()
+}
+
+// Knock 验证来自 addr 的敲门数据报，通过后放行其IP
+// 先检查时间戳再验签，伪造的过期数据报不消耗验签的开销
+func (this *Gate) Knock(packet []byte, addr net.Addr) error {
+	ip, ok := addrIP(addr)
+	if !ok || len(packet) <= headerSize || packet[0] != version {
+		return ErrInvalidPacket
+	}
+	ipLen := int(packet[headerSize-1])
+	if ipLen != 0 && ipLen != net.IPv4len && ipLen != net.IPv6len || len(packet) <= headerSize+ipLen {
+		return ErrInvalidPacket
+	}
+	now := time.Now()
+	sent := time.UnixMilli(int64(binary.BigEndian.Uint64(packet[1:])))
+	if sent.Before(now.Add(-maxClockSkew)) || sent.After(now.Add(maxClockSkew)) {
+		return ErrExpired
+	}
+	signed := packet[:headerSize+ipLen]
+	if !this.signer.Verify(signed, packet[headerSize+ipLen:]) {
+		return ErrBadSignature
+	}
+	if ipLen > 0 {
+		requested, _ := netip.AddrFromSlice(signed[headerSize:])
+		if requested.Unmap() != ip {
+			return ErrAddrMismatch
+		}
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], packet[9:9+nonceSize])
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	if ipLen == 0 && this.requireSourceIP {
+		return ErrAddrMismatch
+	}
+	this.sweep(now)
+	if _, ok := this.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	// 超出时钟偏差的数据报已被拒绝，随机数只需保留到数据报过期
+	this.nonces[nonce] = sent.Add(maxClockSkew)
+	this.allowed[ip] = now.Add(this.window)
+	return nil
+}
+
+// Allowed 判断IP是否在放行期内
+func (this *Gate) Allowed(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	deadline, ok := this.allowed[addr.Unmap()]
+	return ok && time.Now().Before(deadline)
+}
+
+// AllowedAddr 判断网络地址是否在放行期内，非IP地址（如 Unix socket）总是允许
+func (this *Gate) AllowedAddr(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return this.Allowed(addr.IP)
+	case *net.UDPAddr:
+		return this.Allowed(addr.IP)
+	case *net.IPAddr:
+		return this.Allowed(addr.IP)
+	}
+	return true
+}
+
+// Revoke 撤销IP的放行
+func (this *Gate) Revoke(ip net.IP) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return
+	}
+	this.locker.Lock()
+	delete(this.allowed, addr.Unmap())
+	this.locker.Unlock()
+}
+
+// 清理过期的放行记录及随机数，最多每秒一次
+func (this *Gate) sweep(now time.Time) {
+	if now.Before(this.nextSweep) {
+		return
+	}
+	this.nextSweep = now.Add(time.Second)
+	for ip, deadline := range this.allowed {
+		if !now.Before(deadline) {
+			delete(this.allowed, ip)
+		}
+	}
+	for nonce, expire := range this.nonces {
+		if now.After(expire) {
+			delete(this.nonces, nonce)
+		}
+	}
+}
+
+// 取得来源地址的IP
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.IPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, false
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	return a.Unmap(), ok
+}
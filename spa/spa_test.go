@@ -0,0 +1,158 @@
+package spa
+
+import (
+	"github.com/1uLang/libnet/encrypt"
+	"net"
+	"testing"
+	"time"
+)
+
+var testAddr = &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}
+
+func newTestGate(t *testing.T, window time.Duration) (*Gate, encrypt.Signer) {
+	signer, err := encrypt.NewHMACSHA256Signer([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(signer, window), signer
+}
+
+func TestGate_Knock(t *testing.T) {
+	gate, signer := newTestGate(t, time.Minute)
+	if gate.Allowed(testAddr.IP) {
+		t.Fatal("should not be allowed before knock")
+	}
+	packet, err := NewPacket(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gate.Knock(packet, testAddr); err != nil {
+		t.Fatal(err)
+	}
+	if !gate.Allowed(testAddr.IP) || !gate.AllowedAddr(&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 443}) {
+		t.Fatal("should be allowed after knock")
+	}
+	if gate.Allowed(net.ParseIP("10.0.0.2")) {
+		t.Fatal("other ip should not be allowed")
+	}
+
+	// 重放的数据报被拒绝
+	if err := gate.Knock(packet, &net.UDPAddr{IP: net.ParseIP("10.0.0.2")}); err != ErrReplayed {
+		t.Fatal("unexpected replay error:", err)
+	}
+
+	gate.Revoke(testAddr.IP)
+	if gate.Allowed(testAddr.IP) {
+		t.Fatal("should not be allowed after revoke")
+	}
+}
+
+func TestGate_Reject(t *testing.T) {
+	gate, signer := newTestGate(t, time.Minute)
+	packet, err := NewPacket(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := encrypt.NewHMACSHA256Signer([]byte("other"))
+	forged, _ := NewPacket(other)
+	tampered := append([]byte(nil), packet...)
+	tampered[10]++
+	stale, _ := newPacket(signer, nil, time.Now().Add(-time.Minute))
+	future, _ := newPacket(signer, nil, time.Now().Add(time.Minute))
+	// 签名错误的过期数据报在验签之前被拒绝
+	staleForged, _ := newPacket(other, nil, time.Now().Add(-time.Minute))
+	otherIP, _ := NewPacketWithIP(signer, net.ParseIP("10.0.0.2"))
+	badIPLen := append([]byte(nil), packet...)
+	badIPLen[headerSize-1] = 3
+
+	cases := []struct {
+		packet []byte
+		addr   net.Addr
+		err    error
+	}{
+		{packet[:headerSize], testAddr, ErrInvalidPacket},
+		{packet, &net.UnixAddr{Name: "/tmp/spa.sock", Net: "unixgram"}, ErrInvalidPacket},
+		{forged, testAddr, ErrBadSignature},
+		{tampered, testAddr, ErrBadSignature},
+		{stale, testAddr, ErrExpired},
+		{future, testAddr, ErrExpired},
+		{staleForged, testAddr, ErrExpired},
+		{otherIP, testAddr, ErrAddrMismatch},
+		{badIPLen, testAddr, ErrInvalidPacket},
+	}
+	for i, c := range cases {
+		if err := gate.Knock(c.packet, c.addr); err != c.err {
+			t.Fatal(i, "unexpected error:", err)
+		}
+	}
+	if gate.Allowed(testAddr.IP) {
+		t.Fatal("should not be allowed")
+	}
+}
+
+func TestGate_SourceIP(t *testing.T) {
+	gate, signer := newTestGate(t, time.Minute)
+	gate.SetRequireSourceIP(true)
+	packet, _ := NewPacket(signer)
+	if err := gate.Knock(packet, testAddr); err != ErrAddrMismatch {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, ip := range []string{"10.0.0.1", "::ffff:10.0.0.1"} {
+		packet, _ := NewPacketWithIP(signer, net.ParseIP(ip))
+		if err := gate.Knock(packet, testAddr); err != nil {
+			t.Fatal(ip, err)
+		}
+	}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}
+	packet, _ = NewPacketWithIP(signer, v6.IP)
+	if err := gate.Knock(packet, v6); err != nil {
+		t.Fatal(err)
+	}
+	if !gate.Allowed(testAddr.IP) || !gate.Allowed(v6.IP) {
+		t.Fatal("should be allowed after knock")
+	}
+}
+
+func TestGate_Window(t *testing.T) {
+	gate, signer := newTestGate(t, 50*time.Millisecond)
+	packet, _ := NewPacket(signer)
+	if err := gate.Knock(packet, testAddr); err != nil {
+		t.Fatal(err)
+	}
+	if !gate.Allowed(testAddr.IP) {
+		t.Fatal("should be allowed after knock")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if gate.Allowed(testAddr.IP) {
+		t.Fatal("should not be allowed after window")
+	}
+}
+
+func TestKnock(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	gate, signer := newTestGate(t, time.Minute)
+	for _, knock := range []func() error{
+		func() error { return Knock("udp", pc.LocalAddr().String(), signer) },
+		func() error { return KnockWithIP("udp", pc.LocalAddr().String(), signer, net.ParseIP("127.0.0.1")) },
+	} {
+		if err := knock(); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gate.Knock(buf[:n], addr); err != nil {
+			t.Fatal(err)
+		}
+		if !gate.AllowedAddr(addr) {
+			t.Fatal("should be allowed after knock")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package utils
+
+// ConsumeBuffers 跳过已写入的 n 个字节，返回剩余的缓冲区
+func ConsumeBuffers(bufs [][]byte, n int) [][]byte {
+	for len(bufs) > 0 {
+		if n < len(bufs[0]) {
+			bufs[0] = bufs[0][n:]
+			return bufs
+		}
+		n -= len(bufs[0])
+		bufs = bufs[1:]
+	}
+	return bufs
+}
@@ -1,5 +1,7 @@
 package utils
 
+import "sync/atomic"
+
 // pool for get byte slice
 type BytePool struct {
 	c      chan []byte
@@ -7,6 +9,9 @@ type BytePool struct {
 	ticker *Ticker
 
 	lastSize int
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
 }
 
 // 创建新对象
@@ -33,7 +38,9 @@ func (this *BytePool) start() {
 func (this *BytePool) Get() (b []byte) {
 	select {
 	case b = <-this.c:
+		this.hits.Add(1)
 	default:
+		this.misses.Add(1)
 		b = make([]byte, this.length)
 	}
 	return
@@ -56,6 +63,11 @@ func (this *BytePool) Size() int {
 	return len(this.c)
 }
 
+// Stats 取得命中及未命中（新分配）的次数
+func (this *BytePool) Stats() (hits, misses uint64) {
+	return this.hits.Load(), this.misses.Load()
+}
+
 // 销毁
 func (this *BytePool) Destroy() {
 	this.ticker.Stop()
@@ -1,21 +1,16 @@
 //go:build !windows
 
-// 可以在 /usr/include/asm-generic/socket.h 中找到 SO_REUSEPORT 值
-
 package utils
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"syscall"
 )
 
-const SO_REUSEPORT = 15
-
-// 从连接中读取数据
+// 从连接中读取数据，对端关闭时返回 0 及 nil，无数据可读时返回 -1 及 syscall.EAGAIN
 func ReadConn(syscallConn syscall.RawConn, buf []byte) (n int, err error) {
-	err = syscallConn.Read(func(fd uintptr) (done bool) {
+	rawErr := syscallConn.Read(func(fd uintptr) (done bool) {
 		err = syscall.SetNonblock(int(fd), true)
 		if err != nil {
 			n = -1
@@ -29,23 +24,51 @@ func ReadConn(syscallConn syscall.RawConn, buf []byte) (n int, err error) {
 
 		return true
 	})
+	if rawErr != nil {
+		return 0, rawErr
+	}
+	return
+}
+
+// 向连接非阻塞写入数据，发送缓冲区已满时返回 syscall.EAGAIN
+func WriteConn(syscallConn syscall.RawConn, buf []byte) (n int, err error) {
+	rawErr := syscallConn.Write(func(fd uintptr) (done bool) {
+		n, err = syscall.Write(int(fd), buf)
+		if err != nil {
+			n = 0
+		}
+		return true
+	})
+	if rawErr != nil {
+		return 0, rawErr
+	}
 	return
 }
 
 // 监听可重用的端口
 func ListenReuseAddr(network string, addr string) (net.Listener, error) {
-	config := &net.ListenConfig{
+	return reuseListenConfig().Listen(context.Background(), network, addr)
+}
+
+// 监听可重用的数据报端口
+func ListenReusePacket(network string, addr string) (net.PacketConn, error) {
+	return reuseListenConfig().ListenPacket(context.Background(), network, addr)
+}
+
+func reuseListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{
 		Control: func(network, address string, c syscall.RawConn) error {
-			return c.Control(func(fd uintptr) {
-				err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, SO_REUSEPORT, 1)
-				if err != nil {
-					fmt.Println(err)
-				}
+			var err error
+			ctlErr := c.Control(func(fd uintptr) {
+				err = setReusePort(fd)
 			})
+			if ctlErr != nil {
+				return ctlErr
+			}
+			return err
 		},
 		KeepAlive: 0,
 	}
-	return config.Listen(context.Background(), network, addr)
 }
 
 func SetLimit() {
@@ -0,0 +1,18 @@
+//go:build !windows && !solaris
+
+package utils
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+// SO_REUSEPORT 的值因系统而异（Linux 为 15，darwin 及 BSD 为 0x200）
+const SO_REUSEPORT = unix.SO_REUSEPORT
+
+// ReusePortSupported 是否支持以 SO_REUSEPORT 在同一地址打开多个监听
+const ReusePortSupported = true
+
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, SO_REUSEPORT, 1)
+}
@@ -0,0 +1,9 @@
+package utils
+
+// ReusePortSupported 是否支持以 SO_REUSEPORT 在同一地址打开多个监听
+// solaris 及 illumos 不支持在多个 socket 间均衡连接
+const ReusePortSupported = false
+
+func setReusePort(fd uintptr) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package utils
+
+import "testing"
+
+func TestListenReuseAddr(t *testing.T) {
+	if !ReusePortSupported {
+		t.Skip("SO_REUSEPORT not supported")
+	}
+	ln, err := ListenReuseAddr("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// 同一地址再次监听
+	ln2, err := ListenReuseAddr("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ln2.Close()
+
+	pc, err := ListenReusePacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc2, err := ListenReusePacket("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pc2.Close()
+}
@@ -15,13 +15,29 @@ import (
 	"syscall"
 )
 
+// ReusePortSupported 是否支持以 SO_REUSEPORT 在同一地址打开多个监听
+const ReusePortSupported = false
+
 func ReadConn(syscallConn syscall.RawConn, buf []byte) (n int, err error) {
 	return 0, nil
 }
 
-// 监听可重用的端口
+func WriteConn(syscallConn syscall.RawConn, buf []byte) (n int, err error) {
+	return 0, nil
+}
+
+func WritevConn(syscallConn syscall.RawConn, bufs [][]byte) (n int, err error) {
+	return 0, nil
+}
+
+// 监听可重用的端口 windows 不支持 SO_REUSEPORT，直接监听，同一地址只能监听一次
 func ListenReuseAddr(network string, addr string) (net.Listener, error) {
-	return nil, nil
+	return net.Listen(network, addr)
+}
+
+// 监听可重用的数据报端口 windows 不支持 SO_REUSEPORT，直接监听，同一地址只能监听一次
+func ListenReusePacket(network string, addr string) (net.PacketConn, error) {
+	return net.ListenPacket(network, addr)
 }
 
 func SetLimit() {
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"net"
+)
+
+// Message 批量收发的数据报
+type Message struct {
+	Buf  []byte   // 读取时为接收缓冲区，写入时为待发送的数据
+	N    int      // 读取到的字节数
+	Addr net.Addr // 读取时为来源地址，写入时为目标地址
+}
+
+// 平台相关的批量收发实现
+type batchIO interface {
+	read(msgs []Message) (int, error)
+	write(msgs []Message) (int, error)
+}
+
+// PacketBatch 批量收发数据报
+// Linux 下的 UDP socket 使用 recvmmsg/sendmmsg 一次系统调用收发多个数据报，其他情况逐个收发
+type PacketBatch struct {
+	conn  net.PacketConn
+	batch batchIO
+}
+
+// NewPacketBatch 创建批量收发，size 为单次系统调用收发的最大数据报数
+func NewPacketBatch(conn net.PacketConn, size int) *PacketBatch {
+	if size <= 0 {
+		panic("size must greater than 0")
+	}
+	return &PacketBatch{
+		conn:  conn,
+		batch: newBatchIO(conn, size),
+	}
+}
+
+// Native 是否使用 recvmmsg/sendmmsg
+func (b *PacketBatch) Native() bool {
+	return b.batch != nil
+}
+
+// ReadBatch 阻塞读取至少一个数据报，返回读取到的数量，msgs 的 Buf 不能为空
+func (b *PacketBatch) ReadBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if b.batch != nil {
+		return b.batch.read(msgs)
+	}
+	n, addr, err := b.conn.ReadFrom(msgs[0].Buf)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N, msgs[0].Addr = n, addr
+	return 1, nil
+}
+
+// WriteBatch 发送所有数据报，返回成功发送的数量，出错时停止发送
+func (b *PacketBatch) WriteBatch(msgs []Message) (int, error) {
+	if b.batch != nil {
+		sent := 0
+		for sent < len(msgs) {
+			n, err := b.batch.write(msgs[sent:])
+			sent += n
+			if err != nil {
+				return sent, err
+			}
+		}
+		return sent, nil
+	}
+	for i, msg := range msgs {
+		if _, err := b.conn.WriteTo(msg.Buf, msg.Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// recvmmsg/sendmmsg 的参数
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// 一组 mmsghdr 及其引用的缓冲区描述和地址
+type mmsgs struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrInet6 // 足够容纳 IPv4 及 IPv6 地址
+}
+
+func newMmsgs(size int) *mmsgs {
+	return &mmsgs{
+		hdrs:  make([]mmsghdr, size),
+		iovs:  make([]unix.Iovec, size),
+		names: make([]unix.RawSockaddrInet6, size),
+	}
+}
+
+// 使用 recvmmsg/sendmmsg 批量收发
+type mmsgBatch struct {
+	raw   syscall.RawConn
+	inet6 bool // socket 为 AF_INET6，发送 IPv4 地址时使用 IPv4 映射地址
+
+	rd     *mmsgs
+	wr     *mmsgs
+	locker sync.Mutex // 发送可能来自多个协程
+}
+
+// 仅 UDP socket 使用 recvmmsg/sendmmsg
+func newBatchIO(conn net.PacketConn, size int) batchIO {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var sa unix.Sockaddr
+	err = raw.Control(func(fd uintptr) {
+		sa, err = unix.Getsockname(int(fd))
+	})
+	if err != nil {
+		return nil
+	}
+	b := &mmsgBatch{raw: raw, rd: newMmsgs(size), wr: newMmsgs(size)}
+	switch sa.(type) {
+	case *unix.SockaddrInet4:
+	case *unix.SockaddrInet6:
+		b.inet6 = true
+	default:
+		return nil
+	}
+	return b
+}
+
+func (b *mmsgBatch) read(msgs []Message) (int, error) {
+	m := b.rd
+	n := len(msgs)
+	if n > len(m.hdrs) {
+		n = len(m.hdrs)
+	}
+	for i := 0; i < n; i++ {
+		if len(msgs[i].Buf) == 0 {
+			return 0, errors.New("empty read buffer")
+		}
+		m.iovs[i].Base = &msgs[i].Buf[0]
+		m.iovs[i].SetLen(len(msgs[i].Buf))
+		m.hdrs[i] = mmsghdr{}
+		m.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&m.names[i]))
+		m.hdrs[i].hdr.Namelen = uint32(unsafe.Sizeof(m.names[i]))
+		m.hdrs[i].hdr.Iov = &m.iovs[i]
+		m.hdrs[i].hdr.SetIovlen(1)
+	}
+	r, err := b.mmsg(unix.SYS_RECVMMSG, m, n, false)
+	for i := 0; i < r; i++ {
+		msgs[i].N = int(m.hdrs[i].len)
+		msgs[i].Addr = sockaddrToUDP(&m.names[i])
+	}
+	return r, err
+}
+
+func (b *mmsgBatch) write(msgs []Message) (int, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	m := b.wr
+	n := len(msgs)
+	if n > len(m.hdrs) {
+		n = len(m.hdrs)
+	}
+	for i := 0; i < n; i++ {
+		m.hdrs[i] = mmsghdr{}
+		namelen, err := b.udpToSockaddr(msgs[i].Addr, &m.names[i])
+		if err != nil {
+			// 先发送之前的数据报
+			if i == 0 {
+				return 0, err
+			}
+			n = i
+			break
+		}
+		m.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&m.names[i]))
+		m.hdrs[i].hdr.Namelen = namelen
+		if len(msgs[i].Buf) > 0 {
+			m.iovs[i].Base = &msgs[i].Buf[0]
+			m.iovs[i].SetLen(len(msgs[i].Buf))
+			m.hdrs[i].hdr.Iov = &m.iovs[i]
+			m.hdrs[i].hdr.SetIovlen(1)
+		}
+	}
+	return b.mmsg(unix.SYS_SENDMMSG, m, n, true)
+}
+
+// 执行 recvmmsg/sendmmsg，无数据可读或发送缓冲区已满时由运行时等待就绪
+func (b *mmsgBatch) mmsg(trap uintptr, m *mmsgs, n int, write bool) (r int, err error) {
+	f := func(fd uintptr) (done bool) {
+		r0, _, errno := syscall.Syscall6(trap, fd, uintptr(unsafe.Pointer(&m.hdrs[0])), uintptr(n), 0, 0, 0)
+		for errno == syscall.EINTR {
+			r0, _, errno = syscall.Syscall6(trap, fd, uintptr(unsafe.Pointer(&m.hdrs[0])), uintptr(n), 0, 0, 0)
+		}
+		if errno == syscall.EAGAIN {
+			return false
+		}
+		if errno != 0 {
+			r, err = 0, errno
+		} else {
+			r = int(r0)
+		}
+		return true
+	}
+	var rawErr error
+	if write {
+		rawErr = b.raw.Write(f)
+	} else {
+		rawErr = b.raw.Read(f)
+	}
+	if rawErr != nil {
+		return 0, rawErr
+	}
+	return r, err
+}
+
+// 解析 recvmmsg 返回的来源地址
+func sockaddrToUDP(sa *unix.RawSockaddrInet6) *net.UDPAddr {
+	switch sa.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return &net.UDPAddr{
+			IP:   net.IPv4(sa4.Addr[0], sa4.Addr[1], sa4.Addr[2], sa4.Addr[3]),
+			Port: ntohs(sa4.Port),
+		}
+	case unix.AF_INET6:
+		addr := &net.UDPAddr{
+			IP:   append(net.IP{}, sa.Addr[:]...),
+			Port: ntohs(sa.Port),
+		}
+		if sa.Scope_id != 0 {
+			addr.Zone = zoneName(int(sa.Scope_id))
+		}
+		return addr
+	}
+	return nil
+}
+
+// 将目标地址写入 sa，返回地址长度
+func (b *mmsgBatch) udpToSockaddr(addr net.Addr, sa *unix.RawSockaddrInet6) (uint32, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || udpAddr == nil {
+		return 0, errors.New("invalid udp address")
+	}
+	*sa = unix.RawSockaddrInet6{}
+	if !b.inet6 {
+		ip := udpAddr.IP.To4()
+		if ip == nil {
+			return 0, errors.New("ipv6 address on ipv4 socket")
+		}
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		sa4.Family = unix.AF_INET
+		sa4.Port = htons(udpAddr.Port)
+		copy(sa4.Addr[:], ip)
+		return unix.SizeofSockaddrInet4, nil
+	}
+	ip := udpAddr.IP.To16()
+	if ip == nil {
+		return 0, errors.New("invalid ip address")
+	}
+	sa.Family = unix.AF_INET6
+	sa.Port = htons(udpAddr.Port)
+	copy(sa.Addr[:], ip)
+	if udpAddr.Zone != "" {
+		sa.Scope_id = zoneIndex(udpAddr.Zone)
+	}
+	return unix.SizeofSockaddrInet6, nil
+}
+
+// 网络字节序的端口
+func ntohs(port uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+	return int(b[0])<<8 | int(b[1])
+}
+
+func htons(port int) uint16 {
+	var v uint16
+	b := (*[2]byte)(unsafe.Pointer(&v))
+	b[0], b[1] = byte(port>>8), byte(port)
+	return v
+}
+
+func zoneName(index int) string {
+	if ifi, err := net.InterfaceByIndex(index); err == nil {
+		return ifi.Name
+	}
+	return strconv.Itoa(index)
+}
+
+func zoneIndex(zone string) uint32 {
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	index, _ := strconv.Atoi(zone)
+	return uint32(index)
+}
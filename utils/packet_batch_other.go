@@ -0,0 +1,10 @@
+//go:build !linux
+
+package utils
+
+import "net"
+
+// 非 Linux 平台逐个收发
+func newBatchIO(conn net.PacketConn, size int) batchIO {
+	return nil
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func listenUDP(t testing.TB) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func newMessages(n, size int) []Message {
+	msgs := make([]Message, n)
+	for i := range msgs {
+		msgs[i].Buf = make([]byte, size)
+	}
+	return msgs
+}
+
+func TestPacketBatch(t *testing.T) {
+	for _, native := range []bool{true, false} {
+		conn := listenUDP(t)
+		b := NewPacketBatch(conn, 8)
+		if !native {
+			b.batch = nil
+		}
+		peers := []net.PacketConn{listenUDP(t), listenUDP(t)}
+		for i, peer := range peers {
+			for j := 0; j < 3; j++ {
+				if _, err := peer.WriteTo([]byte{byte(i), byte(j)}, conn.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		// 读取全部数据报并原样回复
+		msgs := newMessages(8, 64)
+		replies := make([]Message, 0, 6)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for len(replies) < 6 {
+			n, err := b.ReadBatch(msgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range msgs[:n] {
+				peer := int(msg.Buf[0])
+				if msg.N != 2 || msg.Addr.String() != peers[peer].LocalAddr().String() {
+					t.Fatal("unexpected datagram:", msg.N, msg.Addr, peers[peer].LocalAddr())
+				}
+				replies = append(replies, Message{Buf: append([]byte{}, msg.Buf[:msg.N]...), Addr: msg.Addr})
+			}
+		}
+		if n, err := b.WriteBatch(replies); n != 6 || err != nil {
+			t.Fatal("unexpected write result:", n, err)
+		}
+		for i, peer := range peers {
+			buf := make([]byte, 64)
+			for j := 0; j < 3; j++ {
+				_ = peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+				n, _, err := peer.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 2 || buf[0] != byte(i) || buf[1] != byte(j) {
+					t.Fatal("unexpected reply:", buf[:n])
+				}
+			}
+			_ = peer.Close()
+		}
+		_ = conn.Close()
+		if _, err := b.ReadBatch(msgs); err == nil {
+			t.Fatal("expect error after close")
+		}
+	}
+}
+
+func TestPacketBatch_Native(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+	if !NewPacketBatch(conn, 8).Native() {
+		t.Skip("recvmmsg not supported")
+	}
+	unixConn, err := net.ListenPacket("unixgram", t.TempDir()+"/batch.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixConn.Close()
+	if NewPacketBatch(unixConn, 8).Native() {
+		t.Fatal("unixgram should not use recvmmsg")
+	}
+}
+
+// 发送端持续发送，读取 b.N 个数据报
+func benchmarkReadBatch(b *testing.B, native bool) {
+	conn := listenUDP(b)
+	defer conn.Close()
+	batch := NewPacketBatch(conn, 32)
+	if !native {
+		batch.batch = nil
+	}
+	_ = conn.(*net.UDPConn).SetReadBuffer(4 << 20)
+	peer := listenUDP(b)
+	defer peer.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		data := make([]byte, 128)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, _ = peer.WriteTo(data, conn.LocalAddr())
+		}
+	}()
+
+	msgs := newMessages(32, 2048)
+	b.SetBytes(128)
+	b.ResetTimer()
+	for read := 0; read < b.N; {
+		n, err := batch.ReadBatch(msgs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		read += n
+	}
+}
+
+func BenchmarkPacketBatch_ReadMmsg(b *testing.B) {
+	benchmarkReadBatch(b, true)
+}
+
+func BenchmarkPacketBatch_ReadFrom(b *testing.B) {
+	benchmarkReadBatch(b, false)
+}
+
+// 以每批 32 个发送 b.N 个数据报
+func benchmarkWriteBatch(b *testing.B, native bool) {
+	conn := listenUDP(b)
+	defer conn.Close()
+	batch := NewPacketBatch(conn, 32)
+	if !native {
+		batch.batch = nil
+	}
+	peer := listenUDP(b)
+	defer peer.Close()
+	msgs := make([]Message, 32)
+	for i := range msgs {
+		msgs[i] = Message{Buf: make([]byte, 128), Addr: peer.LocalAddr()}
+	}
+	b.SetBytes(128)
+	b.ResetTimer()
+	for sent := 0; sent < b.N; sent += len(msgs) {
+		if _, err := batch.WriteBatch(msgs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPacketBatch_WriteMmsg(b *testing.B) {
+	benchmarkWriteBatch(b, true)
+}
+
+func BenchmarkPacketBatch_WriteTo(b *testing.B) {
+	benchmarkWriteBatch(b, false)
+}
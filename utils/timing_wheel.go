@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 时间轮层数，每层的精度为上一层的 size 倍
+const timingWheelLevels = 4
+
+// TimingWheel 分层时间轮，大量连接共享一个协程管理定时任务，代替每个连接各自的定时器
+// 添加及取消任务均为 O(1)，到期的任务在新的协程中执行
+// 第0层精度为 tick，第 n 层的一格为 tick*size^n，高层的任务到期前逐层下移
+type TimingWheel struct {
+	tick    time.Duration
+	size    int
+	levels  [timingWheelLevels][]*list.List
+	spans   [timingWheelLevels + 1]uint64 // 各层一格对应的 tick 数
+	current uint64                        // 已经过的 tick 数
+
+	locker sync.Mutex
+	ticker *Ticker
+	once   sync.Once
+}
+
+// Timer 时间轮中的任务
+type Timer struct {
+	wheel   *TimingWheel
+	expire  uint64 // 到期的 tick
+	level   int
+	slot    int
+	element *list.Element
+	f       func()
+}
+
+// NewTimingWheel 创建时间轮，tick 为精度，size 为每层的槽数
+func NewTimingWheel(tick time.Duration, size int) *TimingWheel {
+	if tick <= 0 {
+		panic("tick must greater than 0")
+	}
+	if size <= 1 {
+		panic("size must greater than 1")
+	}
+	w := &TimingWheel{
+		tick: tick,
+		size: size,
+	}
+	w.spans[0] = 1
+	for level := range w.levels {
+		w.spans[level+1] = w.spans[level] * uint64(size)
+		w.levels[level] = make([]*list.List, size)
+		for i := range w.levels[level] {
+			w.levels[level][i] = list.New()
+		}
+	}
+	return w
+}
+
+// AfterFunc d 之后在新的协程中执行 f，至多延迟一个 tick，首次添加任务时启动时间轮
+func (w *TimingWheel) AfterFunc(d time.Duration, f func()) *Timer {
+	w.once.Do(w.start)
+
+	// 当前格已经过的时间未知，多等待一格，保证不早于 d 执行
+	ticks := uint64(1)
+	if d > 0 {
+		ticks += uint64((d + w.tick - 1) / w.tick)
+	}
+	t := &Timer{wheel: w, f: f}
+
+	w.locker.Lock()
+	t.expire = w.current + ticks
+	w.place(t)
+	w.locker.Unlock()
+	return t
+}
+
+// Stop 取消任务，任务已执行或已取消时返回 false
+func (t *Timer) Stop() bool {
+	if t == nil {
+		return false
+	}
+	w := t.wheel
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if t.element == nil {
+		return false
+	}
+	w.levels[t.level][t.slot].Remove(t.element)
+	t.element = nil
+	return true
+}
+
+// Close 停止时间轮，未执行的任务将被丢弃
+func (w *TimingWheel) Close() {
+	w.once.Do(func() {})
+	w.locker.Lock()
+	ticker := w.ticker
+	w.ticker = nil
+	w.locker.Unlock()
+	if ticker != nil {
+		ticker.Stop()
+	}
+}
+
+func (w *TimingWheel) start() {
+	ticker := NewTicker(w.tick)
+	w.locker.Lock()
+	w.ticker = ticker
+	w.locker.Unlock()
+	go func() {
+		for ticker.Wait() {
+			for _, f := range w.advance() {
+				go f()
+			}
+		}
+	}()
+}
+
+// 按剩余时间放入对应层的槽中，超出最高层范围的任务放入最高层最后下移的槽
+func (w *TimingWheel) place(t *Timer) {
+	delta := uint64(0)
+	if t.expire > w.current {
+		delta = t.expire - w.current
+	}
+	level := 0
+	for level < timingWheelLevels-1 && delta >= w.spans[level+1] {
+		level++
+	}
+	var slot uint64
+	if delta >= w.spans[timingWheelLevels] {
+		slot = (w.current/w.spans[level] + uint64(w.size) - 1) % uint64(w.size)
+	} else if delta == 0 {
+		slot = w.current % uint64(w.size)
+	} else {
+		slot = (t.expire / w.spans[level]) % uint64(w.size)
+	}
+	t.level, t.slot = level, int(slot)
+	t.element = w.levels[level][slot].PushBack(t)
+}
+
+// 前进一格，高层到期的槽下移后取出第0层到期的任务
+func (w *TimingWheel) advance() []func() {
+	w.locker.Lock()
+	w.current++
+	// 由高到低下移，高层下移的任务可能落入本次需下移的低层槽中
+	top := 0
+	for top < timingWheelLevels-1 && w.current%w.spans[top+1] == 0 {
+		top++
+	}
+	for level := top; level > 0; level-- {
+		slot := w.levels[level][(w.current/w.spans[level])%uint64(w.size)]
+		for e := slot.Front(); e != nil; e = slot.Front() {
+			t := slot.Remove(e).(*Timer)
+			w.place(t)
+		}
+	}
+	slot := w.levels[0][w.current%uint64(w.size)]
+	var expired []func()
+	for e := slot.Front(); e != nil; e = slot.Front() {
+		t := slot.Remove(e).(*Timer)
+		t.element = nil
+		expired = append(expired, t.f)
+	}
+	w.locker.Unlock()
+	return expired
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel(t *testing.T) {
+	w := NewTimingWheel(5*time.Millisecond, 8)
+	defer w.Close()
+
+	fired := make(chan time.Duration, 2)
+	start := time.Now()
+	w.AfterFunc(20*time.Millisecond, func() {
+		fired <- time.Since(start)
+	})
+	// 超过一圈的任务
+	w.AfterFunc(100*time.Millisecond, func() {
+		fired <- time.Since(start)
+	})
+	var stopped int32
+	timer := w.AfterFunc(30*time.Millisecond, func() {
+		atomic.StoreInt32(&stopped, 1)
+	})
+	if !timer.Stop() || timer.Stop() {
+		t.Fatal("unexpected stop result")
+	}
+
+	for _, min := range []time.Duration{20 * time.Millisecond, 100 * time.Millisecond} {
+		select {
+		case d := <-fired:
+			if d < min {
+				t.Fatal("fired too early:", d, min)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timer not fired")
+		}
+	}
+	if atomic.LoadInt32(&stopped) != 0 {
+		t.Fatal("stopped timer fired")
+	}
+}
+
+func TestTimingWheel_Cascade(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond, 4)
+	// 不启动驱动协程，手动前进
+	w.once.Do(func() {})
+
+	fired := map[int]int{}
+	add := func(id int, ticks uint64) *Timer {
+		timer := &Timer{wheel: w, f: func() { fired[id] = int(w.current) }}
+		w.locker.Lock()
+		timer.expire = w.current + ticks
+		w.place(timer)
+		w.locker.Unlock()
+		return timer
+	}
+	// 分布在各层及超出最高层范围的任务
+	expects := map[int]uint64{1: 1, 2: 3, 3: 4, 4: 17, 5: 64, 6: 100, 7: 255, 8: 300}
+	for id, ticks := range expects {
+		add(id, ticks)
+	}
+	canceled := add(9, 50)
+	for i := 0; i < 10; i++ {
+		for _, f := range w.advance() {
+			f()
+		}
+	}
+	if !canceled.Stop() {
+		t.Fatal("stop pending timer fail")
+	}
+	// 前进过程中添加的任务
+	expects[10] = 10 + 37
+	add(10, 37)
+	for i := 10; i < 400; i++ {
+		for _, f := range w.advance() {
+			f()
+		}
+	}
+	for id, tick := range expects {
+		if fired[id] != int(tick) {
+			t.Fatal("timer", id, "fired at", fired[id], "expect", tick)
+		}
+	}
+	if _, ok := fired[9]; ok {
+		t.Fatal("canceled timer fired")
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// 单次 writev 的最大缓冲区数量
+const maxIovecs = 1024
+
+// 向连接非阻塞写入多个缓冲区，使用 writev(2) 一次系统调用发送，发送缓冲区已满时返回 syscall.EAGAIN
+func WritevConn(syscallConn syscall.RawConn, bufs [][]byte) (n int, err error) {
+	iovecs := make([]syscall.Iovec, 0, len(bufs))
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		iovec := syscall.Iovec{Base: &buf[0]}
+		iovec.SetLen(len(buf))
+		iovecs = append(iovecs, iovec)
+		if len(iovecs) == maxIovecs {
+			break
+		}
+	}
+	if len(iovecs) == 0 {
+		return 0, nil
+	}
+	rawErr := syscallConn.Write(func(fd uintptr) (done bool) {
+		r, _, errno := syscall.Syscall(syscall.SYS_WRITEV, fd, uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+		if errno != 0 {
+			n, err = 0, errno
+		} else {
+			n = int(r)
+		}
+		return true
+	})
+	if rawErr != nil {
+		return 0, rawErr
+	}
+	return
+}
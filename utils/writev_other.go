@@ -0,0 +1,23 @@
+//go:build !linux && !windows
+
+package utils
+
+import "syscall"
+
+// 向连接非阻塞写入多个缓冲区，不支持 writev 的平台逐个写入，发送缓冲区已满时返回 syscall.EAGAIN
+func WritevConn(syscallConn syscall.RawConn, bufs [][]byte) (n int, err error) {
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		written, err := WriteConn(syscallConn, buf)
+		n += written
+		if err != nil || written < len(buf) {
+			if n > 0 {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -1,9 +1,13 @@
 package workers
 
 import (
+	"sync/atomic"
 	"time"
 )
 
+// 所有 worker 中等待执行的任务数
+var queueDepth int64
+
 type Worker struct {
 	id       string
 	ch       chan func()
@@ -21,13 +25,21 @@ func NewWorker(id string) *Worker {
 
 func (this *Worker) Run(task func()) {
 	defer func() {
-		recover()
+		if recover() != nil {
+			atomic.AddInt64(&queueDepth, -1)
+		}
 	}()
 
 	// 此处可能造成阻塞，但保证了任务是同步执行的
+	atomic.AddInt64(&queueDepth, 1)
 	this.ch <- task
 }
 
+// QueueDepth 所有 worker 中等待执行的任务数
+func QueueDepth() int64 {
+	return atomic.LoadInt64(&queueDepth)
+}
+
 func (this *Worker) Id() string {
 	return this.id
 }
@@ -55,6 +67,7 @@ func (this *Worker) Close() {
 func (this *Worker) setup() {
 	go func() {
 		for task := range this.ch {
+			atomic.AddInt64(&queueDepth, -1)
 			if task == nil {
 				break
 			}